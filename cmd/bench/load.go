@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jnaraujo/letschat/pkg/account"
+	"github.com/jnaraujo/letschat/pkg/client"
+	"github.com/jnaraujo/letschat/pkg/id"
+	"github.com/jnaraujo/letschat/pkg/protocol"
+)
+
+var errAuthFailed = errors.New("auth failed")
+
+// Config describes a load test.
+type Config struct {
+	Addr    string
+	Clients int
+	Rooms   int
+	// Rate is the number of messages each client sends per second. With zero
+	// the clients only stay connected.
+	Rate float64
+	// RampUp is the time over which the clients connect, evenly spread.
+	RampUp time.Duration
+	// Duration is how long the clients send messages after the ramp-up.
+	Duration time.Duration
+	// Drain is how long the clients wait for messages in flight after they
+	// stop sending.
+	Drain time.Duration
+	// Timeout is how long to wait for the server to answer the auth of a
+	// client.
+	Timeout time.Duration
+	// ClientOptions configure the connections, like the certificates trusted
+	// for wss://.
+	ClientOptions []client.Option
+}
+
+type loadTest struct {
+	cfg Config
+	// runID prefixes the usernames and the messages of the test, so messages
+	// of other clients of the server are not measured.
+	runID string
+	stats *stats
+	// members is the number of clients connected to each room, used to know
+	// how many times each message should be delivered.
+	members []atomic.Int64
+}
+
+// run runs the load test until it ends or ctx is done.
+func run(ctx context.Context, cfg Config) (*Report, error) {
+	t := &loadTest{
+		cfg:     cfg,
+		runID:   string(id.NewID(4)),
+		stats:   newStats(),
+		members: make([]atomic.Int64, cfg.Rooms),
+	}
+
+	roomIDs, err := t.createRooms(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("creating rooms: %w", err)
+	}
+
+	start := time.Now()
+	sendCtx, cancel := context.WithDeadline(ctx, start.Add(cfg.RampUp+cfg.Duration))
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := range cfg.Clients {
+		delay := cfg.RampUp * time.Duration(i) / time.Duration(cfg.Clients)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			t.runClient(ctx, sendCtx, i, delay, roomIDs[i%cfg.Rooms])
+		}()
+	}
+	<-sendCtx.Done()
+	elapsed := time.Since(start)
+	wg.Wait()
+
+	return t.stats.report(cfg, elapsed), nil
+}
+
+// createRooms creates a room for each client group with a separate connection.
+func (t *loadTest) createRooms(ctx context.Context) ([]id.ID, error) {
+	c, acc, err := t.connect(ctx, fmt.Sprintf("b%ssetup", t.runID), "")
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	roomIDs := make([]id.ID, t.cfg.Rooms)
+	for i := range roomIDs {
+		cmd := protocol.NewChatMessage(acc, fmt.Sprintf("new bench-%s-%d", t.runID, i), protocol.ChatRoom{}, time.Now())
+		cmd.IsCommand = true
+		if err := c.WritePacket(cmd.ToPacket()); err != nil {
+			return nil, err
+		}
+
+		res, err := t.readCommandResponse(c)
+		if err != nil {
+			return nil, err
+		}
+		// the response ends with "/join <room ID>"
+		_, roomID, ok := strings.Cut(res, "/join ")
+		if !ok {
+			return nil, errors.New(res)
+		}
+		roomIDs[i] = id.ID(strings.TrimSpace(roomID))
+	}
+	return roomIDs, nil
+}
+
+func (t *loadTest) readCommandResponse(c *client.WSClient) (string, error) {
+	c.Conn.SetReadDeadline(time.Now().Add(t.cfg.Timeout))
+	defer c.Conn.SetReadDeadline(time.Time{})
+
+	for {
+		pkt, err := c.ReadPacket()
+		if err != nil {
+			return "", err
+		}
+		if pkt.Header.PacketType != protocol.PacketTypeMessage {
+			continue
+		}
+		msg, err := protocol.ChatMessageFromPacket(pkt)
+		if err != nil {
+			return "", err
+		}
+		if msg.IsCommand {
+			return msg.Content, nil
+		}
+	}
+}
+
+// connect connects and authenticates a client into the room.
+func (t *loadTest) connect(ctx context.Context, username string, roomID id.ID) (*client.WSClient, *account.Account, error) {
+	c := client.NewWSClient(t.cfg.Addr, t.cfg.ClientOptions...)
+	if err := c.Connect(ctx); err != nil {
+		return nil, nil, fmt.Errorf("connect: %w", err)
+	}
+
+	err := c.WritePacket(protocol.ClientAuthMessage{Username: username, RoomID: roomID}.ToPacket())
+	if err != nil {
+		c.Close()
+		return nil, nil, fmt.Errorf("auth: %w", err)
+	}
+
+	c.Conn.SetReadDeadline(time.Now().Add(t.cfg.Timeout))
+	pkt, err := c.ReadPacket()
+	if err != nil {
+		c.Close()
+		return nil, nil, fmt.Errorf("auth: %w", err)
+	}
+	c.Conn.SetReadDeadline(time.Time{})
+
+	res, err := protocol.ServerAuthMessageFromPacket(pkt)
+	if err != nil {
+		c.Close()
+		return nil, nil, fmt.Errorf("auth: %w", err)
+	}
+	if res.Status != "ok" {
+		c.Close()
+		return nil, nil, fmt.Errorf("%w: %s", errAuthFailed, res.Content)
+	}
+	return c, res.Account, nil
+}
+
+// runClient connects after delay, sends messages until sendCtx is done and
+// waits for the messages in flight before disconnecting.
+func (t *loadTest) runClient(ctx, sendCtx context.Context, n int, delay time.Duration, roomID id.ID) {
+	select {
+	case <-sendCtx.Done():
+		return
+	case <-time.After(delay):
+	}
+
+	c, acc, err := t.connect(ctx, fmt.Sprintf("b%s%d", t.runID, n), roomID)
+	if err != nil {
+		if errors.Is(err, errAuthFailed) {
+			t.stats.authFailures.Add(1)
+		} else {
+			t.stats.connectFailures.Add(1)
+		}
+		t.stats.addError(err)
+		return
+	}
+	t.stats.connected.Add(1)
+
+	// messages sent before the client was counted as a member of the room
+	// are not expected to reach it, so they are not measured
+	joinedAt := time.Now()
+	room := &t.members[n%t.cfg.Rooms]
+	room.Add(1)
+
+	var closing atomic.Bool
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		t.read(c, joinedAt, &closing)
+	}()
+
+	t.send(sendCtx, c, acc, roomID, room, readDone)
+
+	select {
+	case <-readDone:
+	case <-ctx.Done():
+	case <-time.After(t.cfg.Drain):
+	}
+	room.Add(-1)
+	closing.Store(true)
+	c.Close()
+	<-readDone
+}
+
+// send sends messages at the configured rate until ctx is done or the
+// connection breaks.
+func (t *loadTest) send(ctx context.Context, c *client.WSClient, acc *account.Account,
+	roomID id.ID, room *atomic.Int64, readDone <-chan struct{}) {
+	if t.cfg.Rate <= 0 {
+		select {
+		case <-ctx.Done():
+		case <-readDone:
+		}
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / t.cfg.Rate))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-readDone:
+			return
+		case <-ticker.C:
+		}
+
+		// counted before writing, the message can be delivered before the
+		// write returns
+		expected := room.Load()
+		t.stats.expected.Add(expected)
+
+		now := time.Now()
+		content := fmt.Sprintf("%s %d", t.runID, now.UnixNano())
+		msg := protocol.NewChatMessage(acc, content, protocol.ChatRoom{ID: roomID}, now)
+		if err := c.WritePacket(msg.ToPacket()); err != nil {
+			t.stats.expected.Add(-expected)
+			t.stats.addError(fmt.Errorf("write: %w", err))
+			return
+		}
+		t.stats.sent.Add(1)
+	}
+}
+
+// read records the delivery of the messages of the test sent since joinedAt
+// until the connection is closed.
+func (t *loadTest) read(c *client.WSClient, joinedAt time.Time, closing *atomic.Bool) {
+	for {
+		pkt, err := c.ReadPacket()
+		if err != nil {
+			if !closing.Load() {
+				t.stats.disconnects.Add(1)
+				t.stats.addError(fmt.Errorf("read: %w", err))
+			}
+			return
+		}
+		if pkt.Header.PacketType != protocol.PacketTypeMessage {
+			continue
+		}
+
+		msg, err := protocol.ChatMessageFromPacket(pkt)
+		if err != nil {
+			t.stats.addError(fmt.Errorf("read: %w", err))
+			continue
+		}
+		if msg.IsServer || msg.IsCommand {
+			continue
+		}
+		sentAt, ok := t.parseContent(msg.Content)
+		if !ok || sentAt.Before(joinedAt) {
+			continue
+		}
+		t.stats.addDelivery(time.Since(sentAt))
+	}
+}
+
+// parseContent returns the time a message of the test was sent at.
+func (t *loadTest) parseContent(content string) (time.Time, bool) {
+	rest, ok := strings.CutPrefix(content, t.runID+" ")
+	if !ok {
+		return time.Time{}, false
+	}
+	nanos, err := strconv.ParseInt(rest, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, nanos), true
+}
@@ -2,74 +2,93 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"sync"
+	"os"
+	"os/signal"
 	"time"
 
 	"github.com/jnaraujo/letschat/pkg/client"
-	"github.com/jnaraujo/letschat/pkg/protocol"
 )
 
-func main() {
-	maxClients := 1000
-	connectionsPerClient := 10
+const (
+	// minRate is the lowest -rate other than zero, one message per hour, so
+	// the interval between messages fits in a time.Duration.
+	minRate = 1.0 / 3600
+	// maxRate is the highest -rate, at which the clients send a message every
+	// nanosecond.
+	maxRate = float64(time.Second)
+)
 
-	start := time.Now()
-	var wg sync.WaitGroup
-	wg.Add(maxClients)
+func main() {
+	var cfg Config
+	flag.StringVar(&cfg.Addr, "addr", "wss://localhost:2257/lc", "WebSocket URL of the server")
+	flag.IntVar(&cfg.Clients, "clients", 100, "number of clients")
+	flag.IntVar(&cfg.Rooms, "rooms", 1, "number of rooms the clients are spread across")
+	flag.Float64Var(&cfg.Rate, "rate", 1, "messages sent per second by each client, 0 to only keep the clients connected")
+	flag.DurationVar(&cfg.RampUp, "ramp-up", 5*time.Second, "time over which the clients connect")
+	flag.DurationVar(&cfg.Duration, "duration", 30*time.Second, "time the clients send messages after the ramp-up")
+	flag.DurationVar(&cfg.Drain, "drain", 2*time.Second, "time to wait for messages in flight after the clients stop sending")
+	flag.DurationVar(&cfg.Timeout, "timeout", 10*time.Second, "time to wait for the server to answer the auth of a client")
+	jsonOutput := flag.Bool("json", false, "write the report as JSON")
+	caFile := flag.String("ca", "", "PEM file with the CAs trusted to sign the certificate of wss:// servers, instead of the system ones")
+	pin := flag.String("pin", "", "comma-separated certificate fingerprints accepted from wss:// servers, for self-signed certificates")
+	flag.Parse()
 
-	for i := range maxClients {
-		go func() {
-			defer wg.Done()
-			handleClient(i, connectionsPerClient)
-		}()
+	if err := cfg.validate(); err != nil {
+		usageError(err.Error())
 	}
-	wg.Wait()
-	total := time.Since(start)
 
-	fmt.Println(`Total: `, maxClients*connectionsPerClient)
-	fmt.Println(`Msg/Sec:`, maxClients*connectionsPerClient/int(total.Seconds()))
-}
-
-func handleClient(id, N int) {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	client := client.NewWSClient("ws://localhost:3000/lc")
-	err := client.Connect(ctx)
+	var err error
+	cfg.ClientOptions, err = client.TLSOptions(*caFile, *pin)
 	if err != nil {
-		panic(err)
+		usageError(err.Error())
 	}
-	defer client.Close()
 
-	// Auth
-	err = client.WritePacket(protocol.ClientAuthMessage{
-		Username: fmt.Sprintf("username-%d", id),
-	}.ToPacket())
+	// interrupting stops the test early, the report is still written
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	report, err := run(ctx, cfg)
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 
-	pkt, _ := client.ReadPacket()
-	serverAuthMsg, _ := protocol.ServerAuthMessageFromPacket(pkt)
-
-	exampleMessagePkt := protocol.NewChatMessage(
-		serverAuthMsg.Account, fmt.Sprintf("example message %d", id),
-		protocol.ChatRoom{
-			ID: "ALL",
-		}, time.Now(),
-	).ToPacket()
-
-	go func() {
-		for {
-			_, err = client.Read() // Ignora o tipo de mensagem e os dados
-			if err != nil {
-				return
-			}
-		}
-	}()
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+		return
+	}
+	report.WriteText(os.Stdout)
+}
 
-	for range N {
-		client.WritePacket(exampleMessagePkt)
+func (cfg Config) validate() error {
+	switch {
+	case cfg.Clients < 1:
+		return errors.New("-clients must be at least 1")
+	case cfg.Rooms < 1 || cfg.Rooms > cfg.Clients:
+		return errors.New("-rooms must be from 1 to the number of clients")
+	// also rejects NaN
+	case cfg.Rate != 0 && !(cfg.Rate >= minRate && cfg.Rate <= maxRate):
+		return errors.New("-rate must be 0 or from 1/3600, one message per hour, to 1e9")
+	case cfg.RampUp < 0:
+		return errors.New("-ramp-up must not be negative")
+	case cfg.Duration <= 0:
+		return errors.New("-duration must be positive")
+	case cfg.Drain < 0:
+		return errors.New("-drain must not be negative")
+	case cfg.Timeout <= 0:
+		return errors.New("-timeout must be positive")
 	}
+	return nil
+}
+
+func usageError(msg string) {
+	fmt.Fprintln(os.Stderr, msg)
+	flag.Usage()
+	os.Exit(2)
 }
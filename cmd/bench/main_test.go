@@ -0,0 +1,64 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigValidate(t *testing.T) {
+	valid := Config{
+		Clients:  10,
+		Rooms:    2,
+		Rate:     1,
+		RampUp:   time.Second,
+		Duration: time.Second,
+		Drain:    time.Second,
+		Timeout:  time.Second,
+	}
+	assert.NoError(t, valid.validate())
+
+	tests := []struct {
+		name  string
+		apply func(cfg *Config)
+		ok    bool
+	}{
+		{"no clients", func(cfg *Config) { cfg.Clients = 0 }, false},
+		{"more rooms than clients", func(cfg *Config) { cfg.Rooms = 11 }, false},
+		{"only connected", func(cfg *Config) { cfg.Rate = 0 }, true},
+		{"lowest rate", func(cfg *Config) { cfg.Rate = minRate }, true},
+		{"highest rate", func(cfg *Config) { cfg.Rate = maxRate }, true},
+		{"negative rate", func(cfg *Config) { cfg.Rate = -1 }, false},
+		// the interval would overflow a time.Duration
+		{"rate too low", func(cfg *Config) { cfg.Rate = 1e-10 }, false},
+		// the interval would be zero
+		{"rate too high", func(cfg *Config) { cfg.Rate = 2e9 }, false},
+		{"NaN rate", func(cfg *Config) { cfg.Rate = math.NaN() }, false},
+		{"infinite rate", func(cfg *Config) { cfg.Rate = math.Inf(1) }, false},
+		{"negative ramp-up", func(cfg *Config) { cfg.RampUp = -time.Second }, false},
+		{"no ramp-up", func(cfg *Config) { cfg.RampUp = 0 }, true},
+		{"no duration", func(cfg *Config) { cfg.Duration = 0 }, false},
+		{"negative drain", func(cfg *Config) { cfg.Drain = -time.Second }, false},
+		{"no timeout", func(cfg *Config) { cfg.Timeout = 0 }, false},
+	}
+	for _, tt := range tests {
+		cfg := valid
+		tt.apply(&cfg)
+		err := cfg.validate()
+		if tt.ok {
+			assert.NoError(t, err, tt.name)
+		} else {
+			assert.Error(t, err, tt.name)
+		}
+	}
+}
+
+func TestRateInterval(t *testing.T) {
+	// the interval of every valid rate can be used with time.NewTicker
+	for _, rate := range []float64{minRate, 1, maxRate} {
+		interval := time.Duration(float64(time.Second) / rate)
+		assert.Positive(t, interval, rate)
+	}
+}
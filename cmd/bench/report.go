@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Report is the result of a load test. Latencies are in milliseconds.
+type Report struct {
+	Clients int     `json:"clients"`
+	Rooms   int     `json:"rooms"`
+	Rate    float64 `json:"rate"`
+	// Seconds is the time the clients were sending messages, ramp-up included.
+	Seconds float64 `json:"seconds"`
+
+	Connected       int64 `json:"connected"`
+	ConnectFailures int64 `json:"connect_failures"`
+	AuthFailures    int64 `json:"auth_failures"`
+	Disconnects     int64 `json:"disconnects"`
+
+	Sent      int64 `json:"sent"`
+	Expected  int64 `json:"expected_deliveries"`
+	Delivered int64 `json:"delivered"`
+
+	SentPerSecond      float64 `json:"sent_per_second"`
+	DeliveredPerSecond float64 `json:"delivered_per_second"`
+
+	Latency LatencyReport `json:"latency_ms"`
+	// Errors counts each distinct error seen by the clients.
+	Errors map[string]int `json:"errors,omitempty"`
+}
+
+// LatencyReport summarizes the time from sending a message to its delivery.
+type LatencyReport struct {
+	Min  float64 `json:"min"`
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+type stats struct {
+	connected       atomic.Int64
+	connectFailures atomic.Int64
+	authFailures    atomic.Int64
+	disconnects     atomic.Int64
+
+	sent      atomic.Int64
+	expected  atomic.Int64
+	delivered atomic.Int64
+
+	mutex     sync.Mutex
+	latencies []time.Duration
+	errors    map[string]int
+}
+
+func newStats() *stats {
+	return &stats{errors: make(map[string]int)}
+}
+
+func (s *stats) addError(err error) {
+	msg := err.Error()
+	// network errors name the local port, which would make every error of
+	// the clients distinct
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		msg = strings.Replace(msg, opErr.Error(), fmt.Sprintf("%s %s: %v", opErr.Op, opErr.Net, opErr.Err), 1)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.errors[msg]++
+}
+
+func (s *stats) addDelivery(latency time.Duration) {
+	s.delivered.Add(1)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.latencies = append(s.latencies, latency)
+}
+
+func (s *stats) report(cfg Config, elapsed time.Duration) *Report {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	seconds := elapsed.Seconds()
+	r := &Report{
+		Clients:         cfg.Clients,
+		Rooms:           cfg.Rooms,
+		Rate:            cfg.Rate,
+		Seconds:         seconds,
+		Connected:       s.connected.Load(),
+		ConnectFailures: s.connectFailures.Load(),
+		AuthFailures:    s.authFailures.Load(),
+		Disconnects:     s.disconnects.Load(),
+		Sent:            s.sent.Load(),
+		Expected:        s.expected.Load(),
+		Delivered:       s.delivered.Load(),
+		Latency:         summarize(s.latencies),
+	}
+	if seconds > 0 {
+		r.SentPerSecond = float64(r.Sent) / seconds
+		r.DeliveredPerSecond = float64(r.Delivered) / seconds
+	}
+	if len(s.errors) > 0 {
+		r.Errors = s.errors
+	}
+	return r
+}
+
+func summarize(latencies []time.Duration) LatencyReport {
+	if len(latencies) == 0 {
+		return LatencyReport{}
+	}
+	slices.Sort(latencies)
+
+	var total time.Duration
+	for _, l := range latencies {
+		total += l
+	}
+	return LatencyReport{
+		Min:  milliseconds(latencies[0]),
+		Mean: milliseconds(total / time.Duration(len(latencies))),
+		P50:  milliseconds(percentile(latencies, 50)),
+		P90:  milliseconds(percentile(latencies, 90)),
+		P99:  milliseconds(percentile(latencies, 99)),
+		Max:  milliseconds(latencies[len(latencies)-1]),
+	}
+}
+
+// percentile returns the nearest-rank percentile p of the sorted latencies.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank-1, 0)]
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// WriteText writes the report for people to read.
+func (r *Report) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "Clients:   %d connected, %d failed to connect, %d failed to authenticate, %d disconnected\n",
+		r.Connected, r.ConnectFailures, r.AuthFailures, r.Disconnects)
+	fmt.Fprintf(w, "Messages:  %d sent in %.1fs (%.1f/s)\n", r.Sent, r.Seconds, r.SentPerSecond)
+
+	delivered := 100.0
+	if r.Expected > 0 {
+		delivered = float64(r.Delivered) / float64(r.Expected) * 100
+	}
+	fmt.Fprintf(w, "Delivered: %d of %d (%.2f%%, %.1f/s)\n", r.Delivered, r.Expected, delivered, r.DeliveredPerSecond)
+	fmt.Fprintf(w, "Latency:   min %.2fms, mean %.2fms, p50 %.2fms, p90 %.2fms, p99 %.2fms, max %.2fms\n",
+		r.Latency.Min, r.Latency.Mean, r.Latency.P50, r.Latency.P90, r.Latency.P99, r.Latency.Max)
+
+	if len(r.Errors) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "Errors:")
+	errs := make([]string, 0, len(r.Errors))
+	for err := range r.Errors {
+		errs = append(errs, err)
+	}
+	slices.Sort(errs)
+	for _, err := range errs {
+		fmt.Fprintf(w, "  %6d  %s\n", r.Errors[err], err)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPercentile(t *testing.T) {
+	latencies := make([]time.Duration, 100)
+	for i := range latencies {
+		latencies[i] = time.Duration(i+1) * time.Millisecond
+	}
+
+	assert.Equal(t, 1*time.Millisecond, percentile(latencies, 0))
+	assert.Equal(t, 1*time.Millisecond, percentile(latencies, 1))
+	assert.Equal(t, 50*time.Millisecond, percentile(latencies, 50))
+	assert.Equal(t, 90*time.Millisecond, percentile(latencies, 90))
+	assert.Equal(t, 99*time.Millisecond, percentile(latencies, 99))
+	assert.Equal(t, 100*time.Millisecond, percentile(latencies, 100))
+
+	// the nearest rank is rounded up
+	assert.Equal(t, 2*time.Millisecond, percentile(latencies[:3], 50))
+	assert.Equal(t, 7*time.Millisecond, percentile(latencies[6:7], 99))
+}
+
+func TestSummarize(t *testing.T) {
+	assert.Equal(t, LatencyReport{}, summarize(nil))
+
+	latencies := []time.Duration{
+		4 * time.Millisecond, 1 * time.Millisecond, 3 * time.Millisecond, 2 * time.Millisecond,
+	}
+	assert.Equal(t, LatencyReport{Min: 1, Mean: 2.5, P50: 2, P90: 4, P99: 4, Max: 4}, summarize(latencies))
+}
+
+func TestReport(t *testing.T) {
+	s := newStats()
+	s.connected.Add(3)
+	s.disconnects.Add(1)
+	s.sent.Add(10)
+	s.expected.Add(20)
+	for range 15 {
+		s.addDelivery(2 * time.Millisecond)
+	}
+	// errors that only differ in the port of the client are counted together
+	for port := range 2 {
+		s.addError(fmt.Errorf("connect: %w", &net.OpError{
+			Op:     "dial",
+			Net:    "tcp",
+			Source: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000 + port},
+			Addr:   &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2257},
+			Err:    syscall.ECONNREFUSED,
+		}))
+	}
+	s.addError(errors.New("auth: timeout"))
+
+	r := s.report(Config{Clients: 3, Rooms: 1, Rate: 2}, 5*time.Second)
+	assert.Equal(t, int64(3), r.Connected)
+	assert.Equal(t, 2.0, r.SentPerSecond)
+	assert.Equal(t, 3.0, r.DeliveredPerSecond)
+	assert.Equal(t, 2.0, r.Latency.P99)
+	assert.Equal(t, map[string]int{
+		"connect: dial tcp: connection refused": 2,
+		"auth: timeout":                         1,
+	}, r.Errors)
+
+	var buf bytes.Buffer
+	r.WriteText(&buf)
+	assert.Equal(t, `Clients:   3 connected, 0 failed to connect, 0 failed to authenticate, 1 disconnected
+Messages:  10 sent in 5.0s (2.0/s)
+Delivered: 15 of 20 (75.00%, 3.0/s)
+Latency:   min 2.00ms, mean 2.00ms, p50 2.00ms, p90 2.00ms, p99 2.00ms, max 2.00ms
+Errors:
+       1  auth: timeout
+       2  connect: dial tcp: connection refused
+`, buf.String())
+
+	data, err := json.Marshal(r)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"expected_deliveries":20`)
+	assert.Contains(t, string(data), `"latency_ms":{"min":2,`)
+}
+
+func TestReportWithoutMessages(t *testing.T) {
+	r := newStats().report(Config{Clients: 1, Rooms: 1}, 0)
+	assert.Nil(t, r.Errors)
+	assert.Zero(t, r.SentPerSecond)
+
+	var buf bytes.Buffer
+	r.WriteText(&buf)
+	assert.Contains(t, buf.String(), "Delivered: 0 of 0 (100.00%, 0.0/s)")
+	assert.NotContains(t, buf.String(), "Errors:")
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jnaraujo/letschat/pkg/account"
+	"github.com/jnaraujo/letschat/pkg/client"
+	"github.com/jnaraujo/letschat/pkg/id"
+	"github.com/jnaraujo/letschat/pkg/protocol"
+	"github.com/jnaraujo/letschat/pkg/utils"
+)
+
+type session struct {
+	ctx      context.Context
+	client   *client.WSClient
+	account  *account.Account
+	messages *messageCache
+
+	// lastSeen is the last chat message shown and lastRead the last one
+	// reported to the server as read.
+	lastSeen id.ID
+	lastRead id.ID
+	receipts bool
+	// uploads maps the ref of each pending file offer to the path of the file.
+	uploads map[string]string
+	mutex   sync.Mutex
+}
+
+func (s *session) See(msgID id.ID) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.lastSeen = msgID
+}
+
+// MarkRead tells the server that every message shown so far was read.
+func (s *session) MarkRead() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.lastSeen == "" || s.lastSeen == s.lastRead {
+		return nil
+	}
+
+	err := s.client.WritePacket(protocol.ReadMarker{
+		MessageID: s.lastSeen,
+		Receipt:   s.receipts,
+	}.ToPacket())
+	if err != nil {
+		return err
+	}
+	s.lastRead = s.lastSeen
+	return nil
+}
+
+type localCommand func(s *session, args string)
+
+// localCommands are handled by the client itself instead of being sent to the
+// server as a command message.
+var localCommands = map[string]localCommand{
+	"ping":     pingCommand,
+	"latency":  latencyCommand,
+	"edit":     editCommand,
+	"delete":   deleteCommand,
+	"reply":    replyCommand,
+	"thread":   threadCommand,
+	"react":    reactCommand,
+	"read":     readCommand,
+	"receipts": receiptsCommand,
+	"send":     sendCommand,
+	"download": downloadCommand,
+}
+
+// pingCommand measures the round-trip time to the server with an
+// application-level ping and prints it.
+func pingCommand(s *session, _ string) {
+	ctx, cancel := context.WithTimeout(s.ctx, 5*time.Second)
+	defer cancel()
+
+	rtt, err := s.client.MeasureLatency(ctx)
+	if err != nil {
+		fmt.Println("Failed to measure latency.", err)
+		return
+	}
+	fmt.Printf("Pong! %s\n", utils.FormatLatency(rtt))
+}
+
+// latencyCommand prints the last round-trip time measured by the keep-alive
+// pings, without sending a new ping.
+func latencyCommand(s *session, _ string) {
+	fmt.Printf("Latency: %s\n", utils.FormatLatency(s.client.Latency()))
+}
+
+func editCommand(s *session, args string) {
+	ref, content, _ := strings.Cut(args, " ")
+	content = strings.TrimSpace(content)
+	if ref == "" || content == "" {
+		fmt.Println("Usage: /edit <message id> <new content>")
+		return
+	}
+
+	msgID, err := s.messages.Resolve(ref)
+	if err != nil {
+		fmt.Println("Failed to edit message.", err)
+		return
+	}
+
+	err = s.client.WritePacket(protocol.MessageEdit{
+		MessageID: msgID,
+		Content:   content,
+	}.ToPacket())
+	if err != nil {
+		fmt.Println("Failed to send message.", err)
+	}
+}
+
+func deleteCommand(s *session, args string) {
+	ref := strings.TrimSpace(args)
+	if ref == "" {
+		fmt.Println("Usage: /delete <message id>")
+		return
+	}
+
+	msgID, err := s.messages.Resolve(ref)
+	if err != nil {
+		fmt.Println("Failed to delete message.", err)
+		return
+	}
+
+	err = s.client.WritePacket(protocol.MessageDelete{
+		MessageID: msgID,
+	}.ToPacket())
+	if err != nil {
+		fmt.Println("Failed to send message.", err)
+	}
+}
+
+func replyCommand(s *session, args string) {
+	ref, content, _ := strings.Cut(args, " ")
+	content = strings.TrimSpace(content)
+	if ref == "" || content == "" {
+		fmt.Println("Usage: /reply <message id> <content>")
+		return
+	}
+
+	msgID, err := s.messages.Resolve(ref)
+	if err != nil {
+		fmt.Println("Failed to reply to message.", err)
+		return
+	}
+
+	msg := protocol.NewChatMessage(s.account, content, protocol.ChatRoom{}, time.Now())
+	msg.ReplyTo = msgID
+	err = s.client.WritePacket(msg.ToPacket())
+	if err != nil {
+		fmt.Println("Failed to send message.", err)
+	}
+}
+
+// threadCommand resolves the short message id before asking the server for the
+// thread.
+func threadCommand(s *session, args string) {
+	ref := strings.TrimSpace(args)
+	if ref == "" {
+		fmt.Println("Usage: /thread <message id>")
+		return
+	}
+
+	msgID, err := s.messages.Resolve(ref)
+	if err != nil {
+		fmt.Println("Failed to show thread.", err)
+		return
+	}
+
+	msg := protocol.NewChatMessage(s.account, "thread "+string(msgID), protocol.ChatRoom{}, time.Now())
+	msg.IsCommand = true
+	err = s.client.WritePacket(msg.ToPacket())
+	if err != nil {
+		fmt.Println("Failed to send message.", err)
+	}
+}
+
+// reactCommand toggles a reaction: reacting again with the same emoji removes
+// it.
+func reactCommand(s *session, args string) {
+	ref, emoji, _ := strings.Cut(args, " ")
+	emoji = strings.TrimSpace(emoji)
+	if ref == "" || emoji == "" {
+		fmt.Println("Usage: /react <message id> <emoji>")
+		return
+	}
+
+	msgID, err := s.messages.Resolve(ref)
+	if err != nil {
+		fmt.Println("Failed to react to message.", err)
+		return
+	}
+
+	err = s.client.WritePacket(protocol.Reaction{
+		MessageID: msgID,
+		Emoji:     emoji,
+	}.ToPacket())
+	if err != nil {
+		fmt.Println("Failed to send message.", err)
+	}
+}
+
+func readCommand(s *session, _ string) {
+	if err := s.MarkRead(); err != nil {
+		fmt.Println("Failed to send message.", err)
+	}
+}
+
+// receiptsCommand turns read receipts on or off. When on, the room is told
+// which messages were read.
+func receiptsCommand(s *session, args string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch strings.TrimSpace(args) {
+	case "on":
+		s.receipts = true
+	case "off":
+		s.receipts = false
+	default:
+		fmt.Println("Usage: /receipts <on|off>")
+		return
+	}
+	fmt.Printf("Read receipts are %s.\n", strings.TrimSpace(args))
+}
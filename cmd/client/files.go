@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jnaraujo/letschat/pkg/protocol"
+	"github.com/jnaraujo/letschat/pkg/secure"
+)
+
+var errNoAttachment = errors.New("message has no file")
+
+// sendCommand offers a file to the server. The upload starts once the server
+// accepts the offer.
+func sendCommand(s *session, args string) {
+	path := strings.TrimSpace(args)
+	if path == "" {
+		fmt.Println("Usage: /send <path>")
+		return
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		fmt.Println("Failed to send file.", err)
+		return
+	}
+	if !info.Mode().IsRegular() {
+		fmt.Println("Failed to send file. Only regular files can be sent.")
+		return
+	}
+
+	offer := protocol.FileOffer{
+		Ref:      secure.GenerateRandomString(8),
+		Name:     filepath.Base(path),
+		Size:     info.Size(),
+		MIMEType: mime.TypeByExtension(filepath.Ext(path)),
+	}
+
+	s.mutex.Lock()
+	s.uploads[offer.Ref] = path
+	s.mutex.Unlock()
+
+	if err := s.client.WritePacket(offer.ToPacket()); err != nil {
+		fmt.Println("Failed to send message.", err)
+	}
+}
+
+func handleFileOfferResponse(s *session, res protocol.FileOfferResponse) {
+	s.mutex.Lock()
+	path, ok := s.uploads[res.Ref]
+	delete(s.uploads, res.Ref)
+	s.mutex.Unlock()
+
+	if !ok {
+		return
+	}
+	if res.Status != "ok" {
+		fmt.Println("Failed to send file.", res.Content)
+		return
+	}
+
+	go func() {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Println("Failed to send file.", err)
+			return
+		}
+		defer f.Close()
+
+		info, err := f.Stat()
+		if err != nil {
+			fmt.Println("Failed to send file.", err)
+			return
+		}
+
+		// once uploaded, the file is shown as a message like any other
+		_, err = s.client.UploadFile(s.ctx, res, f, info.Size())
+		if err != nil {
+			fmt.Println("Failed to send file.", err)
+		}
+	}()
+}
+
+// downloadCommand saves the file sent in a message to the current directory.
+func downloadCommand(s *session, args string) {
+	ref := strings.TrimSpace(args)
+	if ref == "" {
+		fmt.Println("Usage: /download <message id>")
+		return
+	}
+
+	msgID, err := s.messages.Resolve(ref)
+	if err != nil {
+		fmt.Println("Failed to download file.", err)
+		return
+	}
+	msg, _ := s.messages.Find(msgID)
+	if msg.Attachment == nil || msg.Deleted {
+		fmt.Println("Failed to download file.", errNoAttachment)
+		return
+	}
+	attachment := *msg.Attachment
+
+	go func() {
+		f, err := createUniqueFile(attachment.Name)
+		if err != nil {
+			fmt.Println("Failed to download file.", err)
+			return
+		}
+		defer f.Close()
+
+		err = s.client.DownloadFile(s.ctx, attachment, f)
+		if err != nil {
+			os.Remove(f.Name())
+			fmt.Println("Failed to download file.", err)
+			return
+		}
+		fmt.Printf("File saved to %s\n", f.Name())
+	}()
+}
+
+// createUniqueFile creates a file in the current directory without replacing
+// existing ones, adding a number to the name if needed.
+func createUniqueFile(name string) (*os.File, error) {
+	name = filepath.Base(name)
+	if name == "." || name == ".." || name == string(filepath.Separator) {
+		name = "download"
+	}
+
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 0; ; i++ {
+		path := name
+		if i > 0 {
+			path = fmt.Sprintf("%s (%d)%s", base, i, ext)
+		}
+
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		return f, err
+	}
+}
@@ -3,21 +3,36 @@ package main
 import (
 	"bufio"
 	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
 	"strings"
 	"time"
 
+	"github.com/fatih/color"
 	"github.com/jnaraujo/letschat/pkg/client"
 	"github.com/jnaraujo/letschat/pkg/id"
 	"github.com/jnaraujo/letschat/pkg/protocol"
 )
 
 const (
-	defaultAddr = "ws://localhost:2257/lc"
+	defaultAddr = "wss://localhost:2257/lc"
 )
 
 func main() {
+	caFile := flag.String("ca", "", "PEM file with the CAs trusted to sign the certificate of wss:// servers, instead of the system ones")
+	pin := flag.String("pin", "", "comma-separated certificate fingerprints accepted from wss:// servers, for self-signed certificates")
+	flag.Parse()
+
+	opts, err := client.TLSOptions(*caFile, *pin)
+	if err != nil {
+		fmt.Println("Failed to read the CA file.", err)
+		return
+	}
+
 	fmt.Println("==================== LetsChat ====================")
 	fmt.Println("Welcome to LetsChat. Insert your credentials below")
 	fmt.Println("to log in.")
@@ -34,9 +49,13 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	if !isSecure(addr) {
+		fmt.Println("Warning: the connection is not encrypted, use a wss:// address to protect your messages.")
+	}
+
 	fmt.Printf("Trying to connect to %s...\n", addr)
-	client := client.NewWSClient(addr)
-	err := client.Connect(ctx)
+	client := client.NewWSClient(addr, opts...)
+	err = client.Connect(ctx)
 	if err != nil {
 		fmt.Println("Failed to connect to the server.", err)
 		return
@@ -58,6 +77,10 @@ func main() {
 	}
 
 	pkt, err := client.ReadPacket()
+	if errors.Is(err, protocol.ErrProtocolVersionMismatch) {
+		fmt.Println("Failed to login. The server uses another version of the protocol, update the client.")
+		return
+	}
 	if err != nil {
 		fmt.Println("Failed to read message.", err)
 		return
@@ -72,6 +95,14 @@ func main() {
 		return
 	}
 
+	s := &session{
+		ctx:      ctx,
+		client:   client,
+		account:  serverAuthMsg.Account,
+		messages: newMessageCache(),
+		uploads:  make(map[string]string),
+	}
+
 	go func() {
 		for {
 			inPkt, err := client.ReadPacket()
@@ -79,24 +110,32 @@ func main() {
 				fmt.Println("Failed to read message.", err)
 				break
 			}
-			incomingMsg, err := protocol.ChatMessageFromPacket(inPkt)
+			err = handlePacket(s, inPkt)
 			if err != nil {
 				fmt.Println("Failed to read message.", err)
 				break
 			}
+		}
+	}()
 
-			// TODO: fix this
-			if incomingMsg.IsCommand && strings.HasPrefix(incomingMsg.Content, "Pong") {
-				incomingMsg.Content = fmt.Sprintf("Pong! %d ms", time.Since(incomingMsg.CreatedAt).Milliseconds())
-			}
+	for {
+		content, lines, ok := readInput(scanner)
+		if !ok {
+			break
+		}
 
-			incomingMsg.Show()
+		// typing something means the messages on the screen were read
+		if err := s.MarkRead(); err != nil {
+			fmt.Println("Failed to send message.", err)
 		}
-	}()
 
-	for scanner.Scan() {
-		content := scanner.Text()
-		content = strings.TrimSpace(content)
+		if strings.HasPrefix(content, "/") {
+			name, args, _ := strings.Cut(content[1:], " ")
+			if command, ok := localCommands[name]; ok {
+				command(s, args)
+				continue
+			}
+		}
 
 		msg := protocol.NewChatMessage(
 			serverAuthMsg.Account, content, protocol.ChatRoom{
@@ -107,7 +146,7 @@ func main() {
 			msg.Content = msg.Content[1:]
 			msg.IsCommand = true
 		} else {
-			clearLine()
+			clearLines(lines)
 		}
 
 		err := client.WritePacket(msg.ToPacket())
@@ -118,7 +157,122 @@ func main() {
 	}
 }
 
-func clearLine() {
-	fmt.Print("\033[1A") // move cursor one line up
-	fmt.Print("\033[K")  // clear the line
+// isSecure reports whether the connection to addr is encrypted or does not
+// leave the machine.
+func isSecure(addr string) bool {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return false
+	}
+	if u.Scheme == "wss" || u.Hostname() == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(u.Hostname())
+	return ip != nil && ip.IsLoopback()
+}
+
+func handlePacket(s *session, pkt *protocol.Packet) error {
+	switch pkt.Header.PacketType {
+	case protocol.PacketTypeMessageEdit:
+		edit, err := protocol.MessageEditFromPacket(pkt)
+		if err != nil {
+			return err
+		}
+		msg, ok := s.messages.Update(edit.MessageID, edit.Apply)
+		if !ok {
+			fmt.Printf("Message #%s was edited: %s\n", protocol.ShortID(edit.MessageID), edit.Content)
+			return nil
+		}
+		msg.Show()
+	case protocol.PacketTypeMessageDelete:
+		del, err := protocol.MessageDeleteFromPacket(pkt)
+		if err != nil {
+			return err
+		}
+		msg, ok := s.messages.Update(del.MessageID, del.Apply)
+		if !ok {
+			fmt.Printf("Message #%s was deleted\n", protocol.ShortID(del.MessageID))
+			return nil
+		}
+		msg.Show()
+	case protocol.PacketTypeReaction:
+		reaction, err := protocol.ReactionFromPacket(pkt)
+		if err != nil {
+			return err
+		}
+		s.messages.Update(reaction.MessageID, reaction.Apply)
+		// removed reactions are not shown to avoid filling the screen
+		if reaction.Added {
+			fmt.Println(color.New(color.Faint).Sprintf("%s reacted with %s to #%s",
+				reaction.Account.Username, reaction.Emoji, protocol.ShortID(reaction.MessageID)))
+		}
+	case protocol.PacketTypeTyping:
+		typing, err := protocol.TypingMessageFromPacket(pkt)
+		if err != nil {
+			return err
+		}
+		// input is only read once a whole line is typed, so this client never
+		// sends typing notifications, but other clients may
+		if typing.Typing && typing.Account.ID != s.account.ID {
+			fmt.Println(color.New(color.Faint).Sprintf("%s is typing...", typing.Account.Username))
+		}
+	case protocol.PacketTypeFileOffer:
+		res, err := protocol.FileOfferResponseFromPacket(pkt)
+		if err != nil {
+			return err
+		}
+		handleFileOfferResponse(s, res)
+	case protocol.PacketTypeReadMarker:
+		marker, err := protocol.ReadMarkerFromPacket(pkt)
+		if err != nil {
+			return err
+		}
+		if marker.Account.ID != s.account.ID {
+			fmt.Println(color.New(color.Faint).Sprintf("%s read up to #%s",
+				marker.Account.Username, protocol.ShortID(marker.MessageID)))
+		}
+	case protocol.PacketTypeMessage:
+		msg, err := protocol.ChatMessageFromPacket(pkt)
+		if err != nil {
+			return err
+		}
+		if !msg.IsCommand && !msg.IsServer {
+			s.messages.Add(msg)
+			s.See(msg.ID)
+		}
+
+		if !msg.IsCommand && msg.Author.ID != s.account.ID && msg.IsMentioned(s.account.ID) {
+			fmt.Print("\a") // terminal bell
+			msg.ShowHighlighted()
+			return nil
+		}
+		msg.Show()
+	default:
+		// sent by a newer server, the rest of the session still works
+		fmt.Println(color.New(color.Faint).Sprintf("Ignored a packet of unknown type %d.", pkt.Header.PacketType))
+	}
+	return nil
+}
+
+// readInput reads the next message typed by the user. A line opening a code
+// block with ``` keeps the message going until the block is closed.
+func readInput(scanner *bufio.Scanner) (string, int, bool) {
+	if !scanner.Scan() {
+		return "", 0, false
+	}
+	content := scanner.Text()
+	lines := 1
+
+	for strings.Count(content, "```")%2 == 1 && scanner.Scan() {
+		content += "\n" + scanner.Text()
+		lines++
+	}
+	return strings.TrimSpace(content), lines, true
+}
+
+func clearLines(n int) {
+	for range n {
+		fmt.Print("\033[1A") // move cursor one line up
+		fmt.Print("\033[K")  // clear the line
+	}
 }
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/jnaraujo/letschat/pkg/id"
+	"github.com/jnaraujo/letschat/pkg/protocol"
+)
+
+const maxCachedMessages = 1000
+
+var (
+	errUnknownMessage   = errors.New("unknown message")
+	errAmbiguousMessage = errors.New("more than one message matches this id")
+)
+
+// messageCache keeps the messages received by the client so they can be
+// referenced by the short id shown next to them.
+type messageCache struct {
+	messages map[id.ID]protocol.ChatMessage
+	order    []id.ID
+	mutex    sync.Mutex
+}
+
+func newMessageCache() *messageCache {
+	return &messageCache{
+		messages: make(map[id.ID]protocol.ChatMessage),
+	}
+}
+
+func (mc *messageCache) Add(msg protocol.ChatMessage) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	if _, exists := mc.messages[msg.ID]; !exists {
+		mc.order = append(mc.order, msg.ID)
+	}
+	mc.messages[msg.ID] = msg
+
+	if len(mc.order) > maxCachedMessages {
+		delete(mc.messages, mc.order[0])
+		mc.order = mc.order[1:]
+	}
+}
+
+func (mc *messageCache) Find(id id.ID) (protocol.ChatMessage, bool) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	msg, ok := mc.messages[id]
+	return msg, ok
+}
+
+// Update changes a cached message in place and returns it. It returns false if
+// the message is not cached.
+func (mc *messageCache) Update(id id.ID, fn func(msg *protocol.ChatMessage)) (protocol.ChatMessage, bool) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	msg, ok := mc.messages[id]
+	if !ok {
+		return msg, false
+	}
+	fn(&msg)
+	mc.messages[id] = msg
+	return msg, true
+}
+
+// Resolve finds the full id of a cached message from a prefix of it.
+func (mc *messageCache) Resolve(prefix string) (id.ID, error) {
+	prefix = strings.TrimPrefix(prefix, "#")
+	if prefix == "" {
+		return "", errUnknownMessage
+	}
+
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	if _, ok := mc.messages[id.ID(prefix)]; ok {
+		return id.ID(prefix), nil
+	}
+
+	var found id.ID
+	for msgID := range mc.messages {
+		if !strings.HasPrefix(string(msgID), prefix) {
+			continue
+		}
+		if found != "" {
+			return "", errAmbiguousMessage
+		}
+		found = msgID
+	}
+	if found == "" {
+		return "", errUnknownMessage
+	}
+	return found, nil
+}
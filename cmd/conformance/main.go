@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/gorilla/websocket"
+	"github.com/jnaraujo/letschat/pkg/client"
+	"github.com/jnaraujo/letschat/pkg/conformance"
+)
+
+func main() {
+	addr := flag.String("addr", "wss://localhost:2257/lc", "WebSocket URL of the server to check")
+	timeout := flag.Duration("timeout", conformance.DefaultTimeout, "how long to wait for each packet from the server")
+	caFile := flag.String("ca", "", "PEM file with the CAs trusted to sign the certificate of wss:// servers, instead of the system ones")
+	pin := flag.String("pin", "", "comma-separated certificate fingerprints accepted from wss:// servers, for self-signed certificates")
+	flag.Parse()
+
+	opts, err := client.TLSOptions(*caFile, *pin)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	runner := conformance.NewRunner(*addr)
+	runner.Timeout = *timeout
+	dialer := *websocket.DefaultDialer
+	dialer.TLSClientConfig = client.NewTLSConfig(opts...)
+	runner.Dialer = &dialer
+
+	failed := runner.Run(context.Background(), os.Stdout)
+	if failed > 0 {
+		fmt.Printf("%d of %d checks failed\n", failed, len(conformance.Checks))
+		os.Exit(1)
+	}
+	fmt.Printf("All %d checks passed\n", len(conformance.Checks))
+}
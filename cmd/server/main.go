@@ -1,20 +1,224 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/jnaraujo/letschat/pkg/audit"
+	"github.com/jnaraujo/letschat/pkg/secure"
 	"github.com/jnaraujo/letschat/pkg/server"
 )
 
-const (
-	addr = ":2257"
-)
-
 func main() {
-	fmt.Printf("Starting server on %s", addr)
-	server := server.NewServer()
-	err := server.Run(addr)
+	addr := flag.String("addr", ":2257", "address to listen on")
+	filesDir := flag.String("files-dir", "", "directory where sent files are stored, empty to disable file transfer")
+	maxFileSize := flag.Int64("max-file-size", server.DefaultMaxFileSize, "maximum size of a sent file in bytes")
+	fileQuota := flag.Int64("file-quota", server.DefaultAddressQuota, "maximum bytes each IP address can store")
+	filesMaxTotal := flag.Int64("files-max-total", server.DefaultMaxTotalSize, "maximum bytes stored for every client together")
+	fileTTL := flag.Duration("file-ttl", server.DefaultFileTTL, "how long sent files are kept")
+	maxMessageLength := flag.Int("max-message-length", server.DefaultMaxMessageLength,
+		fmt.Sprintf("maximum length of a message in characters, up to %d", server.MaxMessageLength))
+	uniqueNames := flag.String("unique-names", "room", "where usernames must be unique: room or server")
+	blockedWords := flag.String("blocked-words", "", "comma-separated words blocked in every room")
+	rejectBlocked := flag.Bool("reject-blocked-words", false, "reject messages with blocked words instead of masking them")
+	blockLinks := flag.Bool("block-links", false, "reject messages with links")
+	allowedHosts := flag.String("allowed-hosts", "", "comma-separated hosts links can point to when -block-links is set")
+	maxRepeated := flag.Int("max-repeated-chars", 0, "shorten runs of the same character longer than this, 0 to disable")
+	webhooks := flag.Bool("webhooks", false, "let room owners and moderators add webhooks with /webhook")
+	webhookDeadLetters := flag.String("webhook-dead-letters", "webhook-dead-letters.jsonl",
+		"file where webhook events that could not be delivered are logged, empty to not log them")
+	webhookHosts := flag.String("webhook-allowed-hosts", "", "comma-separated hosts webhooks can post to, empty to allow any public host")
+	webhookPrivate := flag.Bool("webhook-allow-private", false, "let webhooks post to loopback, private and link-local addresses")
+	botsFile := flag.String("bots-file", "", "file with a \"<name> <token>\" line for each bot allowed to use the HTTP API")
+	adminSocket := flag.String("admin-socket", "", "path of the Unix socket of the admin console, empty to disable it")
+	drainDelay := flag.Duration("drain-delay", server.DefaultDrainDelay,
+		"time to fail the readiness probe before shutting down")
+	auditLog := flag.String("audit-log", "audit.jsonl", "file where security-relevant events are logged, empty to disable it")
+	auditMaxSize := flag.Int64("audit-max-size", audit.DefaultMaxSize, "size in bytes at which the audit log is rotated")
+	auditBackups := flag.Int("audit-backups", audit.DefaultMaxBackups, "number of rotated audit logs kept")
+	sendQueueSize := flag.Int("send-queue-size", server.DefaultSendQueueSize,
+		"number of packets waiting to be written each client can have")
+	sendQueueOverflow := flag.String("send-queue-overflow", "disconnect",
+		"what to do when the send queue of a client is full: disconnect or drop-oldest")
+	logMessageContent := flag.Bool("log-message-content", false, "log the content of every message received")
+	tlsCert := flag.String("tls-cert", "", "PEM certificate file to serve HTTPS and wss:// with, reloaded when it changes")
+	tlsKey := flag.String("tls-key", "", "PEM private key file of -tls-cert")
+	tlsSelfSigned := flag.Bool("tls-self-signed", true,
+		"without -tls-cert, serve HTTPS with a self-signed certificate kept in -tls-dir; false to serve plain HTTP")
+	tlsDir := flag.String("tls-dir", "tls", "directory where the self-signed certificate and its key are kept")
+	tlsHosts := flag.String("tls-hosts", "localhost,127.0.0.1,::1",
+		"comma-separated names and IP addresses of the self-signed certificate when it is generated")
+	roomMetrics := flag.Int("metrics-rooms", 0,
+		"number of rooms with the most clients whose clients are reported by room ID in /metrics, 0 to disable it")
+	healthAddr := flag.String("health-addr", "", "address to also serve /healthz and /readyz on over plain HTTP, empty to disable it")
+	flag.Parse()
+
+	overflowPolicy, err := server.ParseOverflowPolicy(*sendQueueOverflow)
 	if err != nil {
 		panic(err)
 	}
+
+	opts := []server.Option{
+		server.WithMaxMessageLength(*maxMessageLength),
+		server.WithDrainDelay(*drainDelay),
+		// read from the environment, so it does not show up in the process list
+		server.WithAdminToken(os.Getenv("LETSCHAT_ADMIN_TOKEN")),
+		server.WithMessageContentLogging(*logMessageContent),
+		server.WithSendQueue(*sendQueueSize, overflowPolicy),
+		server.WithRoomMetrics(*roomMetrics),
+	}
+	switch *uniqueNames {
+	case "room":
+		opts = append(opts, server.WithNamePolicy(server.UniqueNamesPerRoom))
+	case "server":
+		opts = append(opts, server.WithNamePolicy(server.UniqueNamesPerServer))
+	default:
+		panic(fmt.Sprintf("invalid -unique-names value %q", *uniqueNames))
+	}
+	opts = append(opts, server.WithWordFilter(
+		server.NewWordFilter(splitList(*blockedWords), *rejectBlocked),
+	))
+	if *blockLinks {
+		opts = append(opts, server.WithMessageFilters(server.LinkFilter(splitList(*allowedHosts)...)))
+	}
+	if *maxRepeated > 0 {
+		opts = append(opts, server.WithMessageFilters(server.RepeatFilter(*maxRepeated)))
+	}
+	if *webhooks {
+		var deadLetters io.Writer
+		if *webhookDeadLetters != "" {
+			f, err := os.OpenFile(*webhookDeadLetters, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+			if err != nil {
+				panic(err)
+			}
+			defer f.Close()
+			deadLetters = f
+		}
+		sender := server.NewWebhookSender(deadLetters)
+		sender.AllowedHosts = splitList(*webhookHosts)
+		sender.AllowPrivateNetworks = *webhookPrivate
+		opts = append(opts, server.WithWebhooks(sender))
+	}
+	if *auditLog != "" {
+		l, err := audit.NewLogger(*auditLog, *auditMaxSize, *auditBackups)
+		if err != nil {
+			panic(err)
+		}
+		defer l.Close()
+		opts = append(opts, server.WithAuditLog(l))
+	}
+	if *botsFile != "" {
+		bots, err := readBots(*botsFile)
+		if err != nil {
+			panic(err)
+		}
+		opts = append(opts, bots...)
+	}
+	switch {
+	case *tlsCert != "" || *tlsKey != "":
+		certs, err := server.NewCertReloader(*tlsCert, *tlsKey)
+		if err != nil {
+			panic(err)
+		}
+		opts = append(opts, server.WithTLS(certs.TLSConfig()))
+	case *tlsSelfSigned:
+		certFile := filepath.Join(*tlsDir, "cert.pem")
+		cert, err := server.LoadSelfSignedCertificate(certFile, filepath.Join(*tlsDir, "key.pem"), splitList(*tlsHosts)...)
+		if err != nil {
+			panic(err)
+		}
+		// clients trust the certificate with -ca or by pinning its fingerprint
+		fmt.Printf("Using the self-signed certificate %s, connect with -ca %s or -pin %s\n",
+			certFile, certFile, secure.Fingerprint(cert.Leaf))
+		opts = append(opts, server.WithTLS(server.NewTLSConfig(cert)))
+	default:
+		fmt.Println("Warning: serving plain HTTP, the messages of the clients are not encrypted")
+	}
+	if *filesDir != "" {
+		files, err := server.NewFileStore(*filesDir, *maxFileSize, *fileQuota)
+		if err != nil {
+			panic(err)
+		}
+		files.MaxTotalSize = *filesMaxTotal
+		files.FileTTL = *fileTTL
+		opts = append(opts, server.WithFileStore(files))
+	}
+
+	fmt.Printf("Starting server on %s", *addr)
+	server := server.NewServer(opts...)
+	if *adminSocket != "" {
+		go func() {
+			err := server.RunAdminSocket(*adminSocket)
+			if err != nil {
+				panic(err)
+			}
+		}()
+	}
+
+	if *healthAddr != "" {
+		go func() {
+			err := server.RunHealth(*healthAddr)
+			if err != nil {
+				panic(err)
+			}
+		}()
+	}
+
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		fmt.Println("Shutting down")
+		ctx, cancel := context.WithTimeout(context.Background(), *drainDelay+10*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
+
+	err = server.Run(*addr)
+	if err != nil {
+		panic(err)
+	}
+	// Run returns as soon as Shutdown starts closing the server
+	<-shutdownDone
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// readBots reads a file with a "<name> <token>" line for each bot. Empty lines
+// and lines starting with # are ignored.
+func readBots(path string) ([]server.Option, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var bots []server.Option
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, token, ok := strings.Cut(line, " ")
+		token = strings.TrimSpace(token)
+		if !ok || token == "" {
+			return nil, fmt.Errorf("%s:%d: expected \"<name> <token>\"", path, i+1)
+		}
+		bots = append(bots, server.WithBot(name, token))
+	}
+	return bots, nil
 }
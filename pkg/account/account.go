@@ -7,6 +7,8 @@ import (
 type Account struct {
 	ID       id.ID  `json:"id"`
 	Username string `json:"username"`
+	// Bot is set for accounts that post through the HTTP API.
+	Bot bool `json:"bot,omitempty"`
 }
 
 func NewAccount(username string) *Account {
@@ -15,3 +17,9 @@ func NewAccount(username string) *Account {
 		Username: username,
 	}
 }
+
+func NewBotAccount(username string) *Account {
+	acc := NewAccount(username)
+	acc.Bot = true
+	return acc
+}
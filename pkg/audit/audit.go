@@ -0,0 +1,165 @@
+// Package audit writes security-relevant events to an append-only log of JSON
+// lines, rotated by size.
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jnaraujo/letschat/pkg/account"
+	"github.com/jnaraujo/letschat/pkg/id"
+)
+
+type EventType string
+
+const (
+	AuthSuccess   EventType = "auth.success"
+	AuthFailure   EventType = "auth.failure"
+	AccountRename EventType = "account.rename"
+	RoomCreate    EventType = "room.create"
+	RoomDelete    EventType = "room.delete"
+	RoomJoin      EventType = "room.join"
+	RoomLeave     EventType = "room.leave"
+	MessageDelete EventType = "moderation.message_delete"
+	FilterChange  EventType = "moderation.filter_change"
+	WebhookChange EventType = "moderation.webhook_change"
+	AdminCommand  EventType = "admin.command"
+)
+
+const (
+	DefaultMaxSize    = 10 << 20
+	DefaultMaxBackups = 5
+)
+
+// Event is a line of the audit log. Target is what the action was applied to,
+// like a message ID, and Detail has extra context, like the reason of a
+// failure.
+type Event struct {
+	Time      time.Time `json:"time"`
+	Type      EventType `json:"type"`
+	ActorID   id.ID     `json:"actor_id,omitempty"`
+	ActorName string    `json:"actor_name,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	RoomID    id.ID     `json:"room_id,omitempty"`
+	Target    string    `json:"target,omitempty"`
+	Detail    string    `json:"detail,omitempty"`
+}
+
+// NewEvent returns an event of the type done by the account, which can be nil.
+func NewEvent(eventType EventType, actor *account.Account) Event {
+	ev := Event{
+		Time: time.Now(),
+		Type: eventType,
+	}
+	if actor != nil {
+		ev.ActorID = actor.ID
+		ev.ActorName = actor.Username
+	}
+	return ev
+}
+
+// Logger appends events to a file. When the file grows past the maximum size
+// it is renamed to path.1, the older backups are shifted, and a new file is
+// started. A nil Logger discards every event, so the server does not need to
+// check whether auditing is enabled.
+type Logger struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	file  *os.File
+	size  int64
+	mutex sync.Mutex
+}
+
+func NewLogger(path string, maxSize int64, maxBackups int) (*Logger, error) {
+	l := &Logger{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Logger) Log(ev Event) {
+	if l == nil {
+		return
+	}
+
+	line, err := json.Marshal(ev)
+	if err != nil {
+		panic(err)
+	}
+	line = append(line, '\n')
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			slog.Error("failed to rotate audit log", "err", err)
+		}
+	}
+
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		slog.Error("failed to write audit log", "err", err)
+	}
+}
+
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.file.Close()
+}
+
+func (l *Logger) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file = f
+	l.size = fi.Size()
+	return nil
+}
+
+// rotate starts a new file. If the old one cannot be moved away, it is reopened
+// and the logger keeps appending to it, so no event is written to a closed file.
+func (l *Logger) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return errors.Join(err, l.open())
+	}
+
+	for i := l.maxBackups - 1; i >= 1; i-- {
+		os.Rename(backupPath(l.path, i), backupPath(l.path, i+1))
+	}
+	var err error
+	if l.maxBackups > 0 {
+		err = os.Rename(l.path, backupPath(l.path, 1))
+	} else {
+		err = os.Remove(l.path)
+	}
+	return errors.Join(err, l.open())
+}
+
+func backupPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
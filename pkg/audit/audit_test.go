@@ -0,0 +1,104 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jnaraujo/letschat/pkg/account"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readEvents(t *testing.T, path string) []Event {
+	t.Helper()
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var events []Event
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var ev Event
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &ev))
+		events = append(events, ev)
+	}
+	require.NoError(t, scanner.Err())
+	return events
+}
+
+func TestLogger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := NewLogger(path, DefaultMaxSize, DefaultMaxBackups)
+	require.NoError(t, err)
+
+	acc := account.NewAccount("alice")
+	ev := NewEvent(AuthSuccess, acc)
+	ev.IP = "203.0.113.7"
+	l.Log(ev)
+	l.Log(NewEvent(AuthFailure, nil))
+	require.NoError(t, l.Close())
+
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), fi.Mode().Perm())
+
+	events := readEvents(t, path)
+	require.Len(t, events, 2)
+	assert.Equal(t, AuthSuccess, events[0].Type)
+	assert.Equal(t, acc.ID, events[0].ActorID)
+	assert.Equal(t, "alice", events[0].ActorName)
+	assert.Equal(t, "203.0.113.7", events[0].IP)
+	assert.Equal(t, AuthFailure, events[1].Type)
+	assert.Empty(t, events[1].ActorID)
+
+	// reopening appends to the log
+	l, err = NewLogger(path, DefaultMaxSize, DefaultMaxBackups)
+	require.NoError(t, err)
+	l.Log(NewEvent(RoomCreate, acc))
+	require.NoError(t, l.Close())
+	assert.Len(t, readEvents(t, path), 3)
+}
+
+func TestLoggerRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	// small enough for a single event per file
+	l, err := NewLogger(path, 10, 2)
+	require.NoError(t, err)
+
+	for _, eventType := range []EventType{RoomJoin, RoomLeave, RoomDelete, AdminCommand} {
+		l.Log(NewEvent(eventType, nil))
+	}
+	require.NoError(t, l.Close())
+
+	assert.Equal(t, AdminCommand, readEvents(t, path)[0].Type)
+	assert.Equal(t, RoomDelete, readEvents(t, path+".1")[0].Type)
+	assert.Equal(t, RoomLeave, readEvents(t, path+".2")[0].Type)
+	assert.NoFileExists(t, path+".3")
+}
+
+func TestLoggerRotationFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	// a non-empty directory where the backup goes makes the rename fail
+	require.NoError(t, os.MkdirAll(filepath.Join(path+".1", "dir"), 0o700))
+
+	l, err := NewLogger(path, 10, 1)
+	require.NoError(t, err)
+	for _, eventType := range []EventType{RoomJoin, RoomLeave, RoomDelete} {
+		l.Log(NewEvent(eventType, nil))
+	}
+	require.NoError(t, l.Close())
+
+	events := readEvents(t, path)
+	require.Len(t, events, 3)
+	assert.Equal(t, RoomDelete, events[2].Type)
+}
+
+func TestNilLogger(t *testing.T) {
+	var l *Logger
+	l.Log(NewEvent(AuthSuccess, nil))
+	assert.NoError(t, l.Close())
+}
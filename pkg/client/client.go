@@ -2,6 +2,7 @@ package client
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"net/http"
 	"sync"
@@ -16,14 +17,36 @@ type WSClient struct {
 	Addr string
 	Conn *websocket.Conn
 
+	latency *protocol.LatencyTracker
+	// cancel stops the goroutines of the client.
+	cancel context.CancelFunc
+
+	tlsConfig *tls.Config
+	pins      []string
+	// httpClient makes the requests of file transfers, with the same TLS
+	// configuration as the WebSocket connection.
+	httpClient *http.Client
+
 	rMutex sync.Mutex
 	wMutex sync.Mutex
 }
 
-func NewWSClient(addr string) *WSClient {
-	return &WSClient{
-		Addr: addr,
+func NewWSClient(addr string, opts ...Option) *WSClient {
+	wsc := &WSClient{
+		Addr:      addr,
+		latency:   protocol.NewLatencyTracker(),
+		tlsConfig: &tls.Config{MinVersion: tls.VersionTLS12},
+	}
+	for _, opt := range opts {
+		opt(wsc)
+	}
+	wsc.httpClient = &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: wsc.newTLSConfig(),
+		},
 	}
+	return wsc
 }
 
 func (wsc *WSClient) Connect(ctx context.Context) (err error) {
@@ -31,29 +54,37 @@ func (wsc *WSClient) Connect(ctx context.Context) (err error) {
 		Proxy:             http.ProxyFromEnvironment,
 		HandshakeTimeout:  45 * time.Second,
 		EnableCompression: true,
+		TLSClientConfig:   wsc.newTLSConfig(),
 	}
 
-	wsc.keepAlive(ctx)
-
 	wsc.Conn, _, err = dialer.DialContext(ctx, wsc.Addr, nil)
-	return err
+	if err != nil {
+		return err
+	}
+	wsc.Conn.SetReadLimit(protocol.MaxPacketSize)
+
+	ctx, wsc.cancel = context.WithCancel(ctx)
+	go wsc.keepAlive(ctx)
+	return nil
 }
 
+// keepAlive pings the server until ctx is done or the connection breaks.
 func (wsc *WSClient) keepAlive(ctx context.Context) {
-	go func() {
-		ticker := time.NewTicker(server.MaxPing)
-		for {
-			select {
-			case <-ctx.Done():
-				ticker.Stop()
-			case <-ticker.C:
-				err := wsc.Ping()
-				if err != nil {
-					return
-				}
+	ticker := time.NewTicker(server.MaxPing)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ping, _ := wsc.latency.NewPing()
+			err := wsc.WritePacket(ping.ToPacket())
+			if err != nil {
+				return
 			}
 		}
-	}()
+	}
 }
 
 func (wsc *WSClient) Ping() error {
@@ -92,15 +123,68 @@ func (wsc *WSClient) WritePacket(pkt *protocol.Packet) error {
 	return wsc.Write(data)
 }
 
+// ReadPacket reads the next packet from the server. Ping and pong packets are
+// handled here and never returned to the caller.
 func (wsc *WSClient) ReadPacket() (*protocol.Packet, error) {
-	data, err := wsc.Read()
-	if err != nil {
-		return nil, err
+	for {
+		data, err := wsc.Read()
+		if err != nil {
+			return nil, err
+		}
+
+		pkt, err := protocol.PacketFromBytes(data)
+		if err != nil {
+			return nil, err
+		}
+
+		switch pkt.Header.PacketType {
+		case protocol.PacketTypePing:
+			ping, err := protocol.PingMessageFromPacket(pkt)
+			if err != nil {
+				return nil, err
+			}
+			if err := wsc.WritePacket(ping.Pong().ToPacket()); err != nil {
+				return nil, err
+			}
+		case protocol.PacketTypePong:
+			pong, err := protocol.PongMessageFromPacket(pkt)
+			if err != nil {
+				return nil, err
+			}
+			wsc.latency.HandlePong(pong)
+		default:
+			return pkt, nil
+		}
 	}
+}
 
-	return protocol.PacketFromBytes(data)
+// Latency returns the last round-trip time measured by the client, or zero if
+// none was measured yet.
+func (wsc *WSClient) Latency() time.Duration {
+	return wsc.latency.RTT()
 }
 
+// MeasureLatency sends a ping and waits for its pong. Another goroutine must be
+// calling ReadPacket for the pong to be received.
+func (wsc *WSClient) MeasureLatency(ctx context.Context) (time.Duration, error) {
+	ping, done := wsc.latency.NewPing()
+	if err := wsc.WritePacket(ping.ToPacket()); err != nil {
+		return 0, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case rtt := <-done:
+		return rtt, nil
+	}
+}
+
+// Close stops the goroutines of the client and closes its connection.
 func (wsc *WSClient) Close() error {
+	if wsc.cancel != nil {
+		wsc.cancel()
+	}
+	wsc.httpClient.CloseIdleConnections()
 	return wsc.Conn.Close()
 }
@@ -0,0 +1,74 @@
+package client
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jnaraujo/letschat/pkg/secure"
+	"github.com/jnaraujo/letschat/pkg/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+)
+
+func TestCloseStopsKeepAlive(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	s := server.NewServer()
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	// the context outlives the client, so only Close can stop its goroutines
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := NewWSClient("ws" + strings.TrimPrefix(ts.URL, "http") + "/lc")
+	require.NoError(t, client.Connect(ctx))
+	require.NoError(t, client.Close())
+}
+
+// newTLSServer serves a server over wss:// with a new self-signed certificate.
+func newTLSServer(t *testing.T) (string, *x509.Certificate) {
+	t.Helper()
+	cert, err := server.SelfSignedCertificate("127.0.0.1")
+	require.NoError(t, err)
+
+	s := server.NewServer()
+	ts := httptest.NewUnstartedServer(s.Handler())
+	ts.TLS = server.NewTLSConfig(cert)
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+	return "wss" + strings.TrimPrefix(ts.URL, "https") + "/lc", cert.Leaf
+}
+
+func TestConnectTLS(t *testing.T) {
+	addr, cert := newTLSServer(t)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	tests := []struct {
+		name string
+		opts []Option
+		err  bool
+	}{
+		{name: "system CAs", err: true},
+		{name: "custom CAs", opts: []Option{WithRootCAs(pool)}},
+		{name: "pinned", opts: []Option{WithPinnedCertificates(secure.Fingerprint(cert))}},
+		{name: "other pin", opts: []Option{WithPinnedCertificates(strings.Repeat("0", 64))}, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewWSClient(addr, tt.opts...)
+			err := client.Connect(context.Background())
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.NoError(t, client.Close())
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/jnaraujo/letschat/pkg/protocol"
+)
+
+// HTTPURL builds the URL of an HTTP endpoint served next to the WebSocket
+// endpoint the client connects to.
+func (wsc *WSClient) HTTPURL(path string) (*url.URL, error) {
+	u, err := url.Parse(wsc.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	}
+
+	ref, err := url.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+	return u.ResolveReference(ref), nil
+}
+
+// UploadFile sends the content of a file accepted by the server.
+func (wsc *WSClient) UploadFile(ctx context.Context, res protocol.FileOfferResponse,
+	body io.Reader, size int64) (protocol.FileAttachment, error) {
+	var attachment protocol.FileAttachment
+
+	u, err := wsc.HTTPURL(res.UploadPath)
+	if err != nil {
+		return attachment, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), body)
+	if err != nil {
+		return attachment, err
+	}
+	req.ContentLength = size
+	req.Header.Set("Authorization", "Bearer "+res.UploadToken)
+
+	httpRes, err := wsc.httpClient.Do(req)
+	if err != nil {
+		return attachment, err
+	}
+	defer httpRes.Body.Close()
+
+	if httpRes.StatusCode != http.StatusCreated {
+		return attachment, responseError(httpRes)
+	}
+
+	err = json.NewDecoder(httpRes.Body).Decode(&attachment)
+	return attachment, err
+}
+
+// DownloadFile writes the content of an attachment to w.
+func (wsc *WSClient) DownloadFile(ctx context.Context, attachment protocol.FileAttachment, w io.Writer) error {
+	u, err := wsc.HTTPURL(attachment.FetchPath)
+	if err != nil {
+		return err
+	}
+	u.RawQuery = url.Values{"token": {attachment.Token}}.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	httpRes, err := wsc.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer httpRes.Body.Close()
+
+	if httpRes.StatusCode != http.StatusOK {
+		return responseError(httpRes)
+	}
+
+	_, err = io.Copy(w, httpRes.Body)
+	return err
+}
+
+func responseError(res *http.Response) error {
+	msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+	return fmt.Errorf("server responded with %s: %s", res.Status, strings.TrimSpace(string(msg)))
+}
@@ -0,0 +1,95 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/jnaraujo/letschat/pkg/secure"
+)
+
+var ErrCertificateNotPinned = errors.New("the certificate of the server is not pinned")
+
+type Option func(wsc *WSClient)
+
+// WithRootCAs verifies the certificate of wss:// servers against the given
+// pool instead of the CAs of the system.
+func WithRootCAs(pool *x509.CertPool) Option {
+	return func(wsc *WSClient) {
+		wsc.tlsConfig.RootCAs = pool
+	}
+}
+
+// WithPinnedCertificates only accepts wss:// servers whose certificate has one
+// of the fingerprints, as returned by secure.Fingerprint. The pin replaces the
+// verification against the CAs, so self-signed certificates can be used.
+func WithPinnedCertificates(fingerprints ...string) Option {
+	return func(wsc *WSClient) {
+		for _, fp := range fingerprints {
+			wsc.pins = append(wsc.pins, strings.ToLower(strings.ReplaceAll(fp, ":", "")))
+		}
+	}
+}
+
+// LoadCertPool reads the PEM certificates in the file into a pool.
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s: no PEM certificates found", path)
+	}
+	return pool, nil
+}
+
+// newTLSConfig returns the TLS configuration of the connections of the client.
+func (wsc *WSClient) newTLSConfig() *tls.Config {
+	config := wsc.tlsConfig.Clone()
+	if len(wsc.pins) > 0 {
+		// checked by verifyPin instead
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = wsc.verifyPin
+	}
+	return config
+}
+
+func (wsc *WSClient) verifyPin(state tls.ConnectionState) error {
+	if len(state.PeerCertificates) == 0 {
+		return ErrCertificateNotPinned
+	}
+	fp := secure.Fingerprint(state.PeerCertificates[0])
+	if !slices.Contains(wsc.pins, fp) {
+		return fmt.Errorf("%w: its fingerprint is %s", ErrCertificateNotPinned, fp)
+	}
+	return nil
+}
+
+// TLSOptions returns the options for the -ca and -pin flags of the commands: a
+// PEM file with the trusted CAs and comma-separated pinned fingerprints. Both
+// can be empty.
+func TLSOptions(caFile, pins string) ([]Option, error) {
+	var opts []Option
+	if caFile != "" {
+		pool, err := LoadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithRootCAs(pool))
+	}
+	if pins != "" {
+		opts = append(opts, WithPinnedCertificates(strings.Split(pins, ",")...))
+	}
+	return opts, nil
+}
+
+// NewTLSConfig returns the TLS configuration of a client created with the
+// options, for wss:// connections made without a WSClient.
+func NewTLSConfig(opts ...Option) *tls.Config {
+	return NewWSClient("", opts...).newTLSConfig()
+}
@@ -0,0 +1,440 @@
+// Package conformance checks that a server follows the LetsChat wire protocol.
+// It talks to the server with raw packets, so client implementations can use it
+// to find out how a conforming server behaves, and servers can use it to check
+// they conform.
+package conformance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jnaraujo/letschat/pkg/account"
+	"github.com/jnaraujo/letschat/pkg/id"
+	"github.com/jnaraujo/letschat/pkg/protocol"
+)
+
+const DefaultTimeout = 5 * time.Second
+
+// Check is one behavior of the protocol.
+type Check struct {
+	Name string
+	Run  func(r *Runner) error
+}
+
+// Checks are run in order by Run.
+var Checks = []Check{
+	{"auth", checkAuth},
+	{"auth rejects invalid usernames", checkAuthRejected},
+	{"auth must be the first packet", checkAuthFirst},
+	{"messages are echoed to their author", checkEcho},
+	{"messages are broadcast to the room", checkBroadcast},
+	{"pings are answered with pongs", checkPing},
+	{"commands are answered", checkCommand},
+	{"version mismatch closes the connection", checkVersionMismatch},
+	{"length mismatch closes the connection", checkLengthMismatch},
+	{"oversized messages close the connection", checkOversized},
+}
+
+// Runner runs the checks against the server at Addr, a WebSocket URL like
+// ws://localhost:2257/lc.
+type Runner struct {
+	Addr string
+	// Timeout is how long to wait for each packet expected from the server.
+	Timeout time.Duration
+	Dialer  *websocket.Dialer
+}
+
+func NewRunner(addr string) *Runner {
+	return &Runner{
+		Addr:    addr,
+		Timeout: DefaultTimeout,
+		Dialer:  websocket.DefaultDialer,
+	}
+}
+
+// Run runs every check, writing a line with the result of each to w. It
+// returns the number of checks that failed.
+func (r *Runner) Run(ctx context.Context, w io.Writer) int {
+	failed := 0
+	for _, check := range Checks {
+		if ctx.Err() != nil {
+			fmt.Fprintf(w, "SKIP %s: %v\n", check.Name, ctx.Err())
+			failed++
+			continue
+		}
+		if err := check.Run(r); err != nil {
+			fmt.Fprintf(w, "FAIL %s: %v\n", check.Name, err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(w, "PASS %s\n", check.Name)
+	}
+	return failed
+}
+
+// conn is a connection to the server. Pings sent by the server are answered
+// and never returned by expect.
+type conn struct {
+	ws      *websocket.Conn
+	timeout time.Duration
+	account *account.Account
+}
+
+func (r *Runner) dial() (*conn, error) {
+	ws, _, err := r.Dialer.Dial(r.Addr, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{ws: ws, timeout: r.Timeout}, nil
+}
+
+// auth connects and authenticates with a new username, consuming the join
+// message that follows.
+func (r *Runner) auth() (*conn, error) {
+	c, err := r.dial()
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.authenticate(newUsername())
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	if res.Status != "ok" {
+		c.Close()
+		return nil, fmt.Errorf("auth failed: %s", res.Content)
+	}
+	c.account = res.Account
+	if _, err := c.expectMessage(); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("join message: %w", err)
+	}
+	return c, nil
+}
+
+// newUsername returns a valid username no client is using.
+func newUsername() string {
+	return "cf" + string(id.NewID(8))
+}
+
+func (c *conn) Close() error {
+	return c.ws.Close()
+}
+
+func (c *conn) sendRaw(data []byte) error {
+	c.ws.SetWriteDeadline(time.Now().Add(c.timeout))
+	return c.ws.WriteMessage(websocket.BinaryMessage, data)
+}
+
+func (c *conn) send(pkt *protocol.Packet) error {
+	data, err := pkt.ToBinary()
+	if err != nil {
+		return err
+	}
+	return c.sendRaw(data)
+}
+
+func (c *conn) authenticate(username string) (protocol.ServerAuthMessage, error) {
+	if err := c.send(protocol.ClientAuthMessage{Username: username}.ToPacket()); err != nil {
+		return protocol.ServerAuthMessage{}, err
+	}
+	pkt, err := c.expect(protocol.PacketTypeAuth)
+	if err != nil {
+		return protocol.ServerAuthMessage{}, err
+	}
+	return protocol.ServerAuthMessageFromPacket(pkt)
+}
+
+func (c *conn) read() (*protocol.Packet, error) {
+	for {
+		c.ws.SetReadDeadline(time.Now().Add(c.timeout))
+		messageType, data, err := c.ws.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+		if messageType != websocket.BinaryMessage {
+			return nil, fmt.Errorf("got a WebSocket message of type %d, packets must be binary", messageType)
+		}
+		pkt, err := protocol.PacketFromBytes(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid packet from the server: %w", err)
+		}
+		if pkt.Header.PacketType != protocol.PacketTypePing {
+			return pkt, nil
+		}
+
+		ping, err := protocol.PingMessageFromPacket(pkt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ping from the server: %w", err)
+		}
+		if err := c.send(ping.Pong().ToPacket()); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// expect returns the next packet, which must be of the given type.
+func (c *conn) expect(pktType protocol.PacketType) (*protocol.Packet, error) {
+	pkt, err := c.read()
+	if err != nil {
+		return nil, fmt.Errorf("waiting for a packet of type %d: %w", pktType, err)
+	}
+	if pkt.Header.PacketType != pktType {
+		return nil, fmt.Errorf("got a packet of type %d, want %d: %s", pkt.Header.PacketType, pktType, pkt.Payload)
+	}
+	return pkt, nil
+}
+
+func (c *conn) expectMessage() (protocol.ChatMessage, error) {
+	pkt, err := c.expect(protocol.PacketTypeMessage)
+	if err != nil {
+		return protocol.ChatMessage{}, err
+	}
+	return protocol.ChatMessageFromPacket(pkt)
+}
+
+// expectClosed reads until the server closes the connection.
+func (c *conn) expectClosed() error {
+	for {
+		_, err := c.read()
+		if err == nil {
+			continue
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return errors.New("the server did not close the connection")
+		}
+		return nil
+	}
+}
+
+func checkAuth(r *Runner) error {
+	c, err := r.dial()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	username := newUsername()
+	res, err := c.authenticate(username)
+	if err != nil {
+		return err
+	}
+	switch {
+	case res.Status != "ok":
+		return fmt.Errorf("got status %q, want ok: %s", res.Status, res.Content)
+	case res.Account == nil || res.Account.ID == "":
+		return errors.New("the response has no account")
+	case res.Account.Username != username:
+		return fmt.Errorf("got username %q, want %q", res.Account.Username, username)
+	case res.RoomID == "":
+		return errors.New("the response has no room")
+	}
+
+	msg, err := c.expectMessage()
+	if err != nil {
+		return fmt.Errorf("join message: %w", err)
+	}
+	if !msg.IsServer || msg.Room.ID != res.RoomID {
+		return fmt.Errorf("got %q, want a server message about the join", msg.Content)
+	}
+	return nil
+}
+
+func checkAuthRejected(r *Runner) error {
+	c, err := r.dial()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	res, err := c.authenticate("a")
+	if err != nil {
+		return err
+	}
+	if res.Status != "auth_error" {
+		return fmt.Errorf("got status %q, want auth_error", res.Status)
+	}
+	return c.expectClosed()
+}
+
+func checkAuthFirst(r *Runner) error {
+	c, err := r.dial()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	msg := protocol.NewChatMessage(nil, "hello", protocol.ChatRoom{}, time.Now())
+	if err := c.send(msg.ToPacket()); err != nil {
+		return err
+	}
+	pkt, err := c.expect(protocol.PacketTypeAuth)
+	if err != nil {
+		return err
+	}
+	res, err := protocol.ServerAuthMessageFromPacket(pkt)
+	if err != nil {
+		return err
+	}
+	if res.Status != "auth_error" {
+		return fmt.Errorf("got status %q, want auth_error", res.Status)
+	}
+	return c.expectClosed()
+}
+
+func checkEcho(r *Runner) error {
+	c, err := r.auth()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if err := c.send(protocol.NewChatMessage(c.account, "conformance", protocol.ChatRoom{}, time.Now()).ToPacket()); err != nil {
+		return err
+	}
+	msg, err := c.expectMessage()
+	if err != nil {
+		return err
+	}
+	switch {
+	case msg.Content != "conformance":
+		return fmt.Errorf("got content %q, want %q", msg.Content, "conformance")
+	case msg.Author == nil || msg.Author.ID != c.account.ID:
+		return errors.New("the message is not authored by the client that sent it")
+	case msg.ID == "":
+		return errors.New("the message has no ID")
+	}
+	return nil
+}
+
+func checkBroadcast(r *Runner) error {
+	sender, err := r.auth()
+	if err != nil {
+		return err
+	}
+	defer sender.Close()
+	receiver, err := r.auth()
+	if err != nil {
+		return err
+	}
+	defer receiver.Close()
+
+	if err := sender.send(protocol.NewChatMessage(sender.account, "broadcast", protocol.ChatRoom{}, time.Now()).ToPacket()); err != nil {
+		return err
+	}
+	// the sender may see the join of the receiver first
+	for {
+		msg, err := receiver.expectMessage()
+		if err != nil {
+			return err
+		}
+		if msg.IsServer {
+			continue
+		}
+		if msg.Content != "broadcast" || msg.Author == nil || msg.Author.ID != sender.account.ID {
+			return fmt.Errorf("got %q, want the message of the other client", msg.Content)
+		}
+		return nil
+	}
+}
+
+func checkPing(r *Runner) error {
+	c, err := r.auth()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	ping := protocol.PingMessage{Nonce: string(id.NewID(8)), SentAt: time.Now()}
+	if err := c.send(ping.ToPacket()); err != nil {
+		return err
+	}
+	pkt, err := c.expect(protocol.PacketTypePong)
+	if err != nil {
+		return err
+	}
+	pong, err := protocol.PongMessageFromPacket(pkt)
+	if err != nil {
+		return err
+	}
+	if pong.Nonce != ping.Nonce {
+		return fmt.Errorf("got nonce %q, want %q", pong.Nonce, ping.Nonce)
+	}
+	return nil
+}
+
+func checkCommand(r *Runner) error {
+	c, err := r.auth()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	msg := protocol.NewChatMessage(c.account, "ls", protocol.ChatRoom{}, time.Now())
+	msg.IsCommand = true
+	if err := c.send(msg.ToPacket()); err != nil {
+		return err
+	}
+	res, err := c.expectMessage()
+	if err != nil {
+		return err
+	}
+	if !res.IsCommand {
+		return fmt.Errorf("got %q, want a command response", res.Content)
+	}
+	return nil
+}
+
+func checkVersionMismatch(r *Runner) error {
+	c, err := r.auth()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	pkt := protocol.NewChatMessage(c.account, "hello", protocol.ChatRoom{}, time.Now()).ToPacket()
+	pkt.Header.Version = protocol.ProtocolVersion + 1
+	if err := c.send(pkt); err != nil {
+		return err
+	}
+	return c.expectClosed()
+}
+
+func checkLengthMismatch(r *Runner) error {
+	c, err := r.auth()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	// the header claims more bytes than the payload has
+	data := []byte{byte(protocol.ProtocolVersion), byte(protocol.PacketTypeMessage), 0xff, 0xff, '{', '}'}
+	if err := c.sendRaw(data); err != nil {
+		return err
+	}
+	return c.expectClosed()
+}
+
+func checkOversized(r *Runner) error {
+	c, err := r.auth()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	data := make([]byte, protocol.MaxPacketSize+1)
+	data[0] = byte(protocol.ProtocolVersion)
+	data[1] = byte(protocol.PacketTypeMessage)
+	if err := c.sendRaw(data); err != nil {
+		// the server can close the connection before the whole message is
+		// written
+		return nil
+	}
+	return c.expectClosed()
+}
@@ -0,0 +1,22 @@
+package conformance
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jnaraujo/letschat/pkg/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerConforms(t *testing.T) {
+	s := server.NewServer(server.WithDrainDelay(0))
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+	defer s.Shutdown(context.Background())
+
+	var out strings.Builder
+	failed := NewRunner("ws"+strings.TrimPrefix(ts.URL, "http")+"/lc").Run(context.Background(), &out)
+	assert.Zero(t, failed, out.String())
+}
@@ -14,7 +14,7 @@ type ClientAuthMessage struct {
 
 func ClientAuthMessageFromPacket(pkt *Packet) (ClientAuthMessage, error) {
 	var msg ClientAuthMessage
-	if err := json.Unmarshal(pkt.Payload, &msg); err != nil {
+	if err := decodePayload(pkt, PacketTypeAuth, &msg); err != nil {
 		return msg, err
 	}
 	return msg, nil
@@ -37,7 +37,7 @@ type ServerAuthMessage struct {
 
 func ServerAuthMessageFromPacket(pkt *Packet) (ServerAuthMessage, error) {
 	var msg ServerAuthMessage
-	if err := json.Unmarshal(pkt.Payload, &msg); err != nil {
+	if err := decodePayload(pkt, PacketTypeAuth, &msg); err != nil {
 		return msg, err
 	}
 	return msg, nil
@@ -4,11 +4,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"hash/fnv"
+	"maps"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/fatih/color"
 	"github.com/jnaraujo/letschat/pkg/account"
 	"github.com/jnaraujo/letschat/pkg/id"
+	"github.com/jnaraujo/letschat/pkg/utils"
 )
 
 type ChatRoom struct {
@@ -24,6 +28,44 @@ type ChatMessage struct {
 	Content   string           `json:"content"`
 	CreatedAt time.Time        `json:"created_at"`
 	IsCommand bool             `json:"is_command"`
+	EditedAt  *time.Time       `json:"edited_at,omitempty"`
+	Deleted   bool             `json:"deleted,omitempty"`
+	// ReplyTo is the ID of the message this one answers. The server fills Quote
+	// with a copy of that message when the reply is sent.
+	ReplyTo id.ID          `json:"reply_to,omitempty"`
+	Quote   *QuotedMessage `json:"quote,omitempty"`
+	// Reactions maps each emoji to the accounts that reacted with it.
+	Reactions  map[string][]id.ID `json:"reactions,omitempty"`
+	Attachment *FileAttachment    `json:"attachment,omitempty"`
+	// Mentions are the accounts mentioned in the message, resolved by the
+	// server. MentionsRoom is set when everyone in the room was mentioned.
+	Mentions     []id.ID `json:"mentions,omitempty"`
+	MentionsRoom bool    `json:"mentions_room,omitempty"`
+}
+
+type QuotedMessage struct {
+	Author  *account.Account `json:"author"`
+	Content string           `json:"content"`
+}
+
+const (
+	// maxQuoteLength is the number of characters of the quoted message shown
+	// in a reply.
+	maxQuoteLength = 50
+	// maxQuotedContent is the number of characters of the quoted message sent
+	// with a reply.
+	maxQuotedContent = 200
+)
+
+func NewQuotedMessage(parent ChatMessage) *QuotedMessage {
+	content := []rune(parent.Content)
+	if len(content) > maxQuotedContent {
+		content = content[:maxQuotedContent]
+	}
+	return &QuotedMessage{
+		Author:  parent.Author,
+		Content: string(content),
+	}
 }
 
 func NewChatMessage(author *account.Account, content string,
@@ -62,7 +104,7 @@ func NewCommandChatMessage(content string, createdAt time.Time) ChatMessage {
 
 func ChatMessageFromPacket(pkt *Packet) (ChatMessage, error) {
 	var msg ChatMessage
-	if err := json.Unmarshal(pkt.Payload, &msg); err != nil {
+	if err := decodePayload(pkt, PacketTypeMessage, &msg); err != nil {
 		return msg, err
 	}
 	return msg, nil
@@ -78,6 +120,16 @@ func (msg ChatMessage) ToPacket() *Packet {
 
 // FIX: move this to another place
 func (msg ChatMessage) Show() {
+	msg.show(false)
+}
+
+// ShowHighlighted shows a message that needs the attention of the user, like
+// one mentioning them.
+func (msg ChatMessage) ShowHighlighted() {
+	msg.show(true)
+}
+
+func (msg ChatMessage) show(highlight bool) {
 	if msg.IsServer {
 		c := color.New(color.Italic, color.Faint)
 		fmt.Printf("[%s] <%s>: %s\n",
@@ -94,13 +146,115 @@ func (msg ChatMessage) Show() {
 	}
 
 	pc := color.New(s2c(string(msg.Author.ID)))
+	faint := color.New(color.Faint)
+
+	content := renderMarkup(msg.Content)
+	if msg.Deleted {
+		content = color.New(color.Italic, color.Faint).Sprint("message deleted")
+	} else if msg.EditedAt != nil {
+		content += " " + faint.Sprint("(edited)")
+	}
+	if msg.Attachment != nil && !msg.Deleted {
+		content += " " + formatAttachment(*msg.Attachment, msg.ID)
+	}
+	if len(msg.Reactions) > 0 {
+		content += " " + formatReactions(msg.Reactions)
+	}
+
+	if msg.Quote != nil {
+		showQuote(*msg.Quote)
+	}
+
+	timestamp := color.HiBlueString(timeFormat(msg.CreatedAt))
+	if highlight {
+		timestamp = color.New(color.BgYellow, color.FgBlack).Sprint(timeFormat(msg.CreatedAt))
+	}
 
-	fmt.Printf("[%s] [%s] <%s> %s: %s\n",
-		color.HiBlueString(timeFormat(msg.CreatedAt)),
+	fmt.Printf("[%s] [%s] %s <%s> %s: %s\n",
+		timestamp,
 		color.HiBlueString(string(msg.Room.Name)),
-		pc.Sprint(string(msg.Author.ID[:6])),
-		pc.Sprint(msg.Author.Username),
-		msg.Content)
+		faint.Sprint("#"+ShortID(msg.ID)),
+		pc.Sprint(ShortID(msg.Author.ID)),
+		formatAuthor(msg.Author, pc),
+		content)
+}
+
+func formatAuthor(author *account.Account, c *color.Color) string {
+	if author.Bot {
+		return c.Sprint(author.Username) + " " + color.New(color.Faint).Sprint("[bot]")
+	}
+	return c.Sprint(author.Username)
+}
+
+// renderMarkup formats the markup of a message for the terminal.
+func renderMarkup(content string) string {
+	var res strings.Builder
+	for _, span := range ParseMarkup(content) {
+		switch span.Kind {
+		case SpanBold:
+			res.WriteString(color.New(color.Bold).Sprint(span.Text))
+		case SpanItalic:
+			res.WriteString(color.New(color.Italic).Sprint(span.Text))
+		case SpanCode:
+			res.WriteString(color.New(color.FgCyan).Sprint(span.Text))
+		case SpanCodeBlock:
+			border := color.New(color.Faint)
+			code := color.New(color.FgCyan)
+			if span.Lang != "" {
+				res.WriteString(border.Sprint(" " + span.Lang))
+			}
+			for _, line := range strings.Split(span.Text, "\n") {
+				res.WriteString("\n" + border.Sprint("  │ ") + code.Sprint(line))
+			}
+		case SpanLink:
+			link := color.New(color.FgBlue, color.Underline)
+			if span.Text == span.URL {
+				res.WriteString(link.Sprint(span.URL))
+			} else {
+				res.WriteString(fmt.Sprintf("%s (%s)", link.Sprint(span.Text), span.URL))
+			}
+		default:
+			res.WriteString(span.Text)
+		}
+	}
+	return res.String()
+}
+
+func formatReactions(reactions map[string][]id.ID) string {
+	emojis := slices.Sorted(maps.Keys(reactions))
+
+	parts := make([]string, 0, len(emojis))
+	for _, emoji := range emojis {
+		parts = append(parts, fmt.Sprintf("[%s %d]", emoji, len(reactions[emoji])))
+	}
+	return color.New(color.Faint).Sprint(strings.Join(parts, " "))
+}
+
+func formatAttachment(attachment FileAttachment, msgID id.ID) string {
+	return color.New(color.Bold).Sprintf("[file: %s, %s, %s] (/download %s)",
+		attachment.Name, utils.FormatSize(attachment.Size), attachment.MIMEType, ShortID(msgID))
+}
+
+func showQuote(quote QuotedMessage) {
+	content := []rune(quote.Content)
+	if len(content) > maxQuoteLength {
+		content = append(content[:maxQuoteLength], '…')
+	}
+
+	c := color.New(color.Faint)
+	fmt.Printf("  %s %s: %s\n",
+		c.Sprint("┌"),
+		color.New(s2c(string(quote.Author.ID))).Sprint(quote.Author.Username),
+		c.Sprint(string(content)),
+	)
+}
+
+// ShortID returns the prefix of an ID shown to users.
+func ShortID(id id.ID) string {
+	if len(id) < 6 {
+		return string(id)
+	}
+	return string(id[:6])
 }
 
 func timeFormat(t time.Time) string {
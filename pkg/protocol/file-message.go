@@ -0,0 +1,69 @@
+package protocol
+
+import (
+	"encoding/json"
+
+	"github.com/jnaraujo/letschat/pkg/id"
+)
+
+// FileOffer asks the server for permission to upload a file to the current
+// room. Ref is chosen by the client and echoed in the FileOfferResponse.
+type FileOffer struct {
+	Ref      string `json:"ref"`
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`
+	MIMEType string `json:"mime_type,omitempty"`
+}
+
+func FileOfferFromPacket(pkt *Packet) (FileOffer, error) {
+	var msg FileOffer
+	if err := decodePayload(pkt, PacketTypeFileOffer, &msg); err != nil {
+		return msg, err
+	}
+	return msg, nil
+}
+
+func (msg FileOffer) ToPacket() *Packet {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+	return NewPacket(PacketTypeFileOffer, payload)
+}
+
+// FileOfferResponse answers a FileOffer. When accepted, the file must be sent
+// with an HTTP PUT to UploadPath, using UploadToken as a bearer token.
+type FileOfferResponse struct {
+	Ref         string `json:"ref"`
+	Status      string `json:"status"`
+	Content     string `json:"content,omitempty"`
+	UploadPath  string `json:"upload_path,omitempty"`
+	UploadToken string `json:"upload_token,omitempty"`
+}
+
+func FileOfferResponseFromPacket(pkt *Packet) (FileOfferResponse, error) {
+	var msg FileOfferResponse
+	if err := decodePayload(pkt, PacketTypeFileOffer, &msg); err != nil {
+		return msg, err
+	}
+	return msg, nil
+}
+
+func (msg FileOfferResponse) ToPacket() *Packet {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+	return NewPacket(PacketTypeFileOffer, payload)
+}
+
+// FileAttachment describes a file sent to a room. It can be fetched with an
+// HTTP GET to FetchPath, passing Token in the token query parameter.
+type FileAttachment struct {
+	ID        id.ID  `json:"id"`
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	MIMEType  string `json:"mime_type"`
+	FetchPath string `json:"fetch_path"`
+	Token     string `json:"token"`
+}
@@ -0,0 +1,85 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// addGoldenSeeds adds the encoded golden packets to the seed corpus.
+func addGoldenSeeds(f *testing.F) {
+	for _, golden := range goldenPackets() {
+		data, err := golden.pkt.ToBinary()
+		require.NoError(f, err)
+		f.Add(data)
+	}
+}
+
+func FuzzPacketFromBytes(f *testing.F) {
+	addGoldenSeeds(f)
+	f.Add([]byte{})
+	f.Add([]byte{byte(ProtocolVersion), 1, 0xff, 0xff})
+	f.Add([]byte{byte(ProtocolVersion + 1), 1, 0, 0})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		pkt, err := PacketFromBytes(data)
+		if err != nil {
+			return
+		}
+		// a packet that was read is written back unchanged
+		encoded, err := pkt.ToBinary()
+		require.NoError(t, err)
+		assert.True(t, bytes.Equal(data, encoded), "packet changed after encoding it again")
+	})
+}
+
+// FuzzDecoders decodes the packets with every payload decoder. Decoding must
+// not panic, and a payload that decodes must encode again.
+func FuzzDecoders(f *testing.F) {
+	addGoldenSeeds(f)
+
+	decoders := make(map[PacketType][]func(pkt *Packet) (any, error))
+	for _, golden := range goldenPackets() {
+		pktType := golden.pkt.Header.PacketType
+		decoders[pktType] = append(decoders[pktType], golden.decode)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		pkt, err := PacketFromBytes(data)
+		if err != nil {
+			return
+		}
+		for pktType, fns := range decoders {
+			for _, decode := range fns {
+				msg, err := decode(pkt)
+				if pktType != pkt.Header.PacketType {
+					require.ErrorIs(t, err, ErrUnexpectedPacketType)
+					continue
+				}
+				if err != nil {
+					continue
+				}
+				encoded, err := msg.(interface{ ToPacket() *Packet }).ToPacket().ToBinary()
+				// escaping can make the payload grow past the limit
+				if errors.Is(err, ErrPayloadTooLarge) {
+					continue
+				}
+				if !assert.NoError(t, err) {
+					continue
+				}
+				_, err = decode(mustPacketFromBytes(t, encoded))
+				assert.NoError(t, err)
+			}
+		}
+	})
+}
+
+func mustPacketFromBytes(t *testing.T, data []byte) *Packet {
+	t.Helper()
+	pkt, err := PacketFromBytes(data)
+	require.NoError(t, err)
+	return pkt
+}
@@ -0,0 +1,92 @@
+package protocol
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jnaraujo/letschat/pkg/account"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+var (
+	goldenTime    = time.Date(2024, time.March, 1, 12, 30, 0, 0, time.UTC)
+	goldenAccount = &account.Account{ID: "K3bQ7zL2mN9xP4vR8tY1wA", Username: "alice"}
+)
+
+// goldenPacket is a packet of the wire format, stored in testdata/golden, and
+// the decoder of its payload.
+type goldenPacket struct {
+	name   string
+	pkt    *Packet
+	decode func(pkt *Packet) (any, error)
+}
+
+func decoder[T any](fn func(pkt *Packet) (T, error)) func(pkt *Packet) (any, error) {
+	return func(pkt *Packet) (any, error) {
+		return fn(pkt)
+	}
+}
+
+func goldenPackets() []goldenPacket {
+	chat := NewChatMessage(goldenAccount, "hello, **world**", ChatRoom{ID: "ALL", Name: "ALL"}, goldenTime)
+	chat.ID = "Hq2Vb8nW4kT6yU1pX9sD3f"
+
+	return []goldenPacket{
+		{"client-auth", ClientAuthMessage{Username: "alice", RoomID: "ALL"}.ToPacket(),
+			decoder(ClientAuthMessageFromPacket)},
+		{"server-auth", ServerAuthMessage{Status: "ok", Content: "account authenticated", RoomID: "ALL", Account: goldenAccount}.ToPacket(),
+			decoder(ServerAuthMessageFromPacket)},
+		{"message", chat.ToPacket(), decoder(ChatMessageFromPacket)},
+		{"ping", PingMessage{Nonce: "n0nce", SentAt: goldenTime}.ToPacket(), decoder(PingMessageFromPacket)},
+		{"pong", PongMessage{Nonce: "n0nce", SentAt: goldenTime, ReceivedAt: goldenTime.Add(time.Millisecond)}.ToPacket(),
+			decoder(PongMessageFromPacket)},
+		{"message-edit", MessageEdit{MessageID: chat.ID, RoomID: "ALL", Content: "hello", EditedBy: goldenAccount, EditedAt: goldenTime}.ToPacket(),
+			decoder(MessageEditFromPacket)},
+		{"message-delete", MessageDelete{MessageID: chat.ID, RoomID: "ALL", DeletedBy: goldenAccount, DeletedAt: goldenTime}.ToPacket(),
+			decoder(MessageDeleteFromPacket)},
+		{"reaction", Reaction{MessageID: chat.ID, RoomID: "ALL", Emoji: "👍", Account: goldenAccount, Added: true}.ToPacket(),
+			decoder(ReactionFromPacket)},
+		{"typing", TypingMessage{RoomID: "ALL", Account: goldenAccount, Typing: true}.ToPacket(),
+			decoder(TypingMessageFromPacket)},
+		{"read-marker", ReadMarker{MessageID: chat.ID, RoomID: "ALL", Account: goldenAccount, Receipt: true, ReadAt: goldenTime}.ToPacket(),
+			decoder(ReadMarkerFromPacket)},
+		{"file-offer", FileOffer{Ref: "1", Name: "notes.txt", Size: 42, MIMEType: "text/plain"}.ToPacket(),
+			decoder(FileOfferFromPacket)},
+		{"file-offer-response", FileOfferResponse{Ref: "1", Status: "ok", UploadPath: "/files/Hq2Vb8nW4kT6yU1pX9sD3f", UploadToken: "t0ken"}.ToPacket(),
+			decoder(FileOfferResponseFromPacket)},
+	}
+}
+
+func TestGolden(t *testing.T) {
+	for _, golden := range goldenPackets() {
+		t.Run(golden.name, func(t *testing.T) {
+			path := filepath.Join("testdata", "golden", golden.name+".bin")
+
+			data, err := golden.pkt.ToBinary()
+			require.NoError(t, err)
+			if *update {
+				require.NoError(t, os.WriteFile(path, data, 0o644))
+			}
+
+			want, err := os.ReadFile(path)
+			require.NoError(t, err)
+			assert.Equal(t, want, data, "encoding changed, run the tests with -update if it is intended")
+
+			pkt, err := PacketFromBytes(want)
+			require.NoError(t, err)
+			assert.Equal(t, golden.pkt, pkt)
+
+			got, err := golden.decode(pkt)
+			require.NoError(t, err)
+			wantMsg, err := golden.decode(golden.pkt)
+			require.NoError(t, err)
+			assert.Equal(t, wantMsg, got)
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package protocol
+
+import (
+	"sync"
+	"time"
+)
+
+// maxPendingPingAge is how long an unanswered ping is remembered before it is
+// considered lost.
+const maxPendingPingAge = 2 * time.Minute
+
+type pendingPing struct {
+	sentAt time.Time
+	done   chan time.Duration
+}
+
+// LatencyTracker matches the pongs received on a connection with the pings sent
+// on it and keeps the last measured round-trip time.
+type LatencyTracker struct {
+	pending map[string]pendingPing
+	rtt     time.Duration
+	mutex   sync.Mutex
+}
+
+func NewLatencyTracker() *LatencyTracker {
+	return &LatencyTracker{
+		pending: make(map[string]pendingPing),
+	}
+}
+
+// NewPing creates a ping to be sent and remembers it. The returned channel
+// receives the round-trip time once the matching pong arrives.
+func (lt *LatencyTracker) NewPing() (PingMessage, <-chan time.Duration) {
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+
+	now := time.Now()
+	for nonce, p := range lt.pending {
+		if now.Sub(p.sentAt) > maxPendingPingAge {
+			delete(lt.pending, nonce)
+		}
+	}
+
+	ping := NewPingMessage()
+	done := make(chan time.Duration, 1)
+	// the local time is kept instead of ping.SentAt, which loses its monotonic
+	// reading once it goes through the wire.
+	lt.pending[ping.Nonce] = pendingPing{sentAt: now, done: done}
+	return ping, done
+}
+
+// HandlePong records the round-trip time for a pong. It returns false if the
+// pong does not answer a ping sent through this tracker.
+func (lt *LatencyTracker) HandlePong(pong PongMessage) (time.Duration, bool) {
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+
+	p, ok := lt.pending[pong.Nonce]
+	if !ok {
+		return 0, false
+	}
+	delete(lt.pending, pong.Nonce)
+
+	lt.rtt = time.Since(p.sentAt)
+	p.done <- lt.rtt
+	return lt.rtt, true
+}
+
+// RTT returns the last measured round-trip time, or zero if no pong was
+// received yet.
+func (lt *LatencyTracker) RTT() time.Duration {
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+	return lt.rtt
+}
@@ -0,0 +1,61 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatencyTracker(t *testing.T) {
+	lt := NewLatencyTracker()
+	assert.Zero(t, lt.RTT())
+
+	first, firstDone := lt.NewPing()
+	second, secondDone := lt.NewPing()
+	assert.NotEqual(t, first.Nonce, second.Nonce)
+
+	// pongs are matched by nonce, whatever order they arrive in
+	rtt, ok := lt.HandlePong(second.Pong())
+	require.True(t, ok)
+	assert.Equal(t, rtt, <-secondDone)
+	assert.Equal(t, rtt, lt.RTT())
+	assert.Empty(t, firstDone)
+
+	rtt, ok = lt.HandlePong(first.Pong())
+	require.True(t, ok)
+	assert.Equal(t, rtt, <-firstDone)
+	assert.Equal(t, rtt, lt.RTT())
+
+	// a pong is only accepted once
+	_, ok = lt.HandlePong(first.Pong())
+	assert.False(t, ok)
+}
+
+func TestLatencyTrackerUnknownPong(t *testing.T) {
+	lt := NewLatencyTracker()
+	lt.NewPing()
+
+	_, ok := lt.HandlePong(PongMessage{Nonce: "unknown"})
+	assert.False(t, ok)
+	assert.Zero(t, lt.RTT())
+}
+
+func TestLatencyTrackerPrunesLostPings(t *testing.T) {
+	lt := NewLatencyTracker()
+	lost, _ := lt.NewPing()
+	recent, _ := lt.NewPing()
+	lt.pending[lost.Nonce] = pendingPing{
+		sentAt: time.Now().Add(-maxPendingPingAge - time.Second),
+		done:   make(chan time.Duration, 1),
+	}
+
+	// pings are pruned when a new one is sent
+	lt.NewPing()
+	assert.NotContains(t, lt.pending, lost.Nonce)
+	assert.Contains(t, lt.pending, recent.Nonce)
+
+	_, ok := lt.HandlePong(lost.Pong())
+	assert.False(t, ok)
+}
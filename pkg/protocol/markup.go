@@ -0,0 +1,177 @@
+package protocol
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type SpanKind uint8
+
+const (
+	SpanText SpanKind = iota
+	SpanBold
+	SpanItalic
+	SpanCode
+	SpanCodeBlock
+	SpanLink
+)
+
+// Span is a piece of formatted text. Lang is only set for code blocks and URL
+// only for links.
+type Span struct {
+	Kind SpanKind
+	Text string
+	Lang string
+	URL  string
+}
+
+// ParseMarkup splits the content of a message into spans using a small subset
+// of markdown: **bold**, *italic* or _italic_, `code`, fenced code blocks with
+// an optional language, [text](https://url) links and bare http(s) URLs.
+// Formatting does not nest and unterminated markers are kept as plain text.
+func ParseMarkup(content string) []Span {
+	p := markupParser{src: content}
+	p.parse()
+	return p.spans
+}
+
+type markupParser struct {
+	src   string
+	spans []Span
+	text  strings.Builder
+}
+
+func (p *markupParser) parse() {
+	for i := 0; i < len(p.src); {
+		if n := p.parseAt(i); n > 0 {
+			i += n
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(p.src[i:])
+		p.text.WriteRune(r)
+		i += size
+	}
+	p.flushText()
+}
+
+// parseAt tries to parse a formatted span starting at i, returning the number
+// of bytes consumed or zero if there is none.
+func (p *markupParser) parseAt(i int) int {
+	rest := p.src[i:]
+
+	switch {
+	case strings.HasPrefix(rest, "```"):
+		return p.parseCodeBlock(rest)
+	case rest[0] == '`':
+		return p.parseDelimited(rest, "`", SpanCode)
+	case strings.HasPrefix(rest, "**"):
+		return p.parseDelimited(rest, "**", SpanBold)
+	case rest[0] == '*':
+		return p.parseDelimited(rest, "*", SpanItalic)
+	case rest[0] == '_' && !p.afterWordChar(i):
+		return p.parseDelimited(rest, "_", SpanItalic)
+	case rest[0] == '[':
+		return p.parseLink(rest)
+	case (strings.HasPrefix(rest, "http://") || strings.HasPrefix(rest, "https://")) &&
+		!p.afterWordChar(i):
+		return p.parseBareURL(rest)
+	}
+	return 0
+}
+
+func (p *markupParser) parseCodeBlock(rest string) int {
+	end := strings.Index(rest[3:], "```")
+	if end == -1 {
+		return 0
+	}
+	body := rest[3 : 3+end]
+
+	var lang string
+	if first, code, ok := strings.Cut(body, "\n"); ok && !strings.ContainsFunc(first, unicode.IsSpace) {
+		lang, body = first, code
+	}
+	body = strings.TrimSuffix(strings.TrimPrefix(body, "\n"), "\n")
+
+	p.add(Span{Kind: SpanCodeBlock, Text: body, Lang: lang})
+	return 3 + end + 3
+}
+
+func (p *markupParser) parseDelimited(rest, delim string, kind SpanKind) int {
+	end := strings.Index(rest[len(delim):], delim)
+	if end <= 0 {
+		return 0
+	}
+	text := rest[len(delim) : len(delim)+end]
+	if kind != SpanCode && (strings.Contains(text, "\n") || strings.TrimSpace(text) != text) {
+		return 0
+	}
+
+	p.add(Span{Kind: kind, Text: text})
+	return len(delim) + end + len(delim)
+}
+
+func (p *markupParser) parseLink(rest string) int {
+	textEnd := strings.Index(rest, "](")
+	if textEnd <= 1 || strings.Contains(rest[:textEnd], "\n") {
+		return 0
+	}
+	urlEnd := strings.IndexByte(rest[textEnd+2:], ')')
+	if urlEnd == -1 {
+		return 0
+	}
+	url := rest[textEnd+2 : textEnd+2+urlEnd]
+	if !isWebURL(url) {
+		return 0
+	}
+
+	p.add(Span{Kind: SpanLink, Text: rest[1:textEnd], URL: url})
+	return textEnd + 2 + urlEnd + 1
+}
+
+func (p *markupParser) parseBareURL(rest string) int {
+	end := strings.IndexFunc(rest, unicode.IsSpace)
+	if end == -1 {
+		end = len(rest)
+	}
+	// punctuation right after an URL most likely ends the sentence
+	url := strings.TrimRight(rest[:end], ".,;:!?)")
+	if !isWebURL(url) {
+		return 0
+	}
+
+	p.add(Span{Kind: SpanLink, Text: url, URL: url})
+	return len(url)
+}
+
+func (p *markupParser) afterWordChar(i int) bool {
+	if i == 0 {
+		return false
+	}
+	r, _ := utf8.DecodeLastRuneInString(p.src[:i])
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func (p *markupParser) add(span Span) {
+	p.flushText()
+	p.spans = append(p.spans, span)
+}
+
+func (p *markupParser) flushText() {
+	if p.text.Len() == 0 {
+		return
+	}
+	p.spans = append(p.spans, Span{Kind: SpanText, Text: p.text.String()})
+	p.text.Reset()
+}
+
+func isWebURL(url string) bool {
+	for _, scheme := range []string{"http://", "https://"} {
+		if strings.HasPrefix(url, scheme) && len(url) > len(scheme) &&
+			!strings.ContainsFunc(url, unicode.IsSpace) {
+			return true
+		}
+	}
+	return false
+}
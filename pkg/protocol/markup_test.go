@@ -0,0 +1,51 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMarkup(t *testing.T) {
+	tests := []struct {
+		content string
+		spans   []Span
+	}{
+		{"hello", []Span{{Kind: SpanText, Text: "hello"}}},
+		{"a **bold** move", []Span{
+			{Kind: SpanText, Text: "a "},
+			{Kind: SpanBold, Text: "bold"},
+			{Kind: SpanText, Text: " move"},
+		}},
+		{"*it* and _it_", []Span{
+			{Kind: SpanItalic, Text: "it"},
+			{Kind: SpanText, Text: " and "},
+			{Kind: SpanItalic, Text: "it"},
+		}},
+		{"snake_case_name", []Span{{Kind: SpanText, Text: "snake_case_name"}}},
+		{"2 * 3 * 4", []Span{{Kind: SpanText, Text: "2 * 3 * 4"}}},
+		{"run `go test`", []Span{
+			{Kind: SpanText, Text: "run "},
+			{Kind: SpanCode, Text: "go test"},
+		}},
+		{"```go\nfmt.Println(1)\n```", []Span{
+			{Kind: SpanCodeBlock, Text: "fmt.Println(1)", Lang: "go"},
+		}},
+		{"see [docs](https://go.dev).", []Span{
+			{Kind: SpanText, Text: "see "},
+			{Kind: SpanLink, Text: "docs", URL: "https://go.dev"},
+			{Kind: SpanText, Text: "."},
+		}},
+		{"go to https://go.dev.", []Span{
+			{Kind: SpanText, Text: "go to "},
+			{Kind: SpanLink, Text: "https://go.dev", URL: "https://go.dev"},
+			{Kind: SpanText, Text: "."},
+		}},
+		{"[bad](javascript:alert(1))", []Span{{Kind: SpanText, Text: "[bad](javascript:alert(1))"}}},
+		{"**unterminated", []Span{{Kind: SpanText, Text: "**unterminated"}}},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.spans, ParseMarkup(test.content), test.content)
+	}
+}
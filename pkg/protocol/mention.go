@@ -0,0 +1,31 @@
+package protocol
+
+import (
+	"regexp"
+	"slices"
+
+	"github.com/jnaraujo/letschat/pkg/id"
+)
+
+// MentionRoom is the mention that notifies everyone in the room.
+const MentionRoom = "room"
+
+var mentionRegex = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_])@([\p{L}\p{M}\p{N}_.\-]+)`)
+
+// ParseMentions returns the names mentioned with @name in the content, without
+// repetitions.
+func ParseMentions(content string) []string {
+	var names []string
+	for _, match := range mentionRegex.FindAllStringSubmatch(content, -1) {
+		if !slices.Contains(names, match[1]) {
+			names = append(names, match[1])
+		}
+	}
+	return names
+}
+
+// IsMentioned reports whether the message mentions the account, directly or
+// through @room.
+func (msg ChatMessage) IsMentioned(accountID id.ID) bool {
+	return msg.MentionsRoom || slices.Contains(msg.Mentions, accountID)
+}
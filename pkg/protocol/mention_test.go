@@ -0,0 +1,16 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMentions(t *testing.T) {
+	assert.Equal(t, []string{"alice", "bob.smith", "room"},
+		ParseMentions("@alice and @bob.smith, @alice again; @room"))
+	assert.Equal(t, []string{"josé"}, ParseMentions("(@josé)"))
+	// addresses are not mentions
+	assert.Empty(t, ParseMentions("mail alice@example.com"))
+	assert.Empty(t, ParseMentions("no mentions @ all"))
+}
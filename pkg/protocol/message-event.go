@@ -0,0 +1,74 @@
+package protocol
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/jnaraujo/letschat/pkg/account"
+	"github.com/jnaraujo/letschat/pkg/id"
+)
+
+// MessageEdit replaces the content of a message. Clients send it with the
+// MessageID and the new Content; the server fills the remaining fields before
+// sending it to the room.
+type MessageEdit struct {
+	MessageID id.ID            `json:"message_id"`
+	RoomID    id.ID            `json:"room_id,omitempty"`
+	Content   string           `json:"content"`
+	EditedBy  *account.Account `json:"edited_by,omitempty"`
+	EditedAt  time.Time        `json:"edited_at"`
+}
+
+func MessageEditFromPacket(pkt *Packet) (MessageEdit, error) {
+	var msg MessageEdit
+	if err := decodePayload(pkt, PacketTypeMessageEdit, &msg); err != nil {
+		return msg, err
+	}
+	return msg, nil
+}
+
+func (msg MessageEdit) ToPacket() *Packet {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+	return NewPacket(PacketTypeMessageEdit, payload)
+}
+
+// Apply updates chatMsg with the edit.
+func (msg MessageEdit) Apply(chatMsg *ChatMessage) {
+	editedAt := msg.EditedAt
+	chatMsg.Content = msg.Content
+	chatMsg.EditedAt = &editedAt
+}
+
+// MessageDelete removes a message. Clients send it with the MessageID; the
+// server fills the remaining fields before sending it to the room.
+type MessageDelete struct {
+	MessageID id.ID            `json:"message_id"`
+	RoomID    id.ID            `json:"room_id,omitempty"`
+	DeletedBy *account.Account `json:"deleted_by,omitempty"`
+	DeletedAt time.Time        `json:"deleted_at"`
+}
+
+func MessageDeleteFromPacket(pkt *Packet) (MessageDelete, error) {
+	var msg MessageDelete
+	if err := decodePayload(pkt, PacketTypeMessageDelete, &msg); err != nil {
+		return msg, err
+	}
+	return msg, nil
+}
+
+func (msg MessageDelete) ToPacket() *Packet {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+	return NewPacket(PacketTypeMessageDelete, payload)
+}
+
+// Apply turns chatMsg into a tombstone.
+func (msg MessageDelete) Apply(chatMsg *ChatMessage) {
+	chatMsg.Content = ""
+	chatMsg.Deleted = true
+}
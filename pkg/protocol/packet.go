@@ -3,15 +3,36 @@ package protocol
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"math"
 )
 
-var ErrProtocolVersionMismatch = errors.New("protocol version mismatch")
+var (
+	ErrProtocolVersionMismatch = errors.New("protocol version mismatch")
+	ErrInvalidPacketLength     = errors.New("packet length does not match its payload")
+	ErrPayloadTooLarge         = errors.New("packet payload is too large")
+	ErrUnexpectedPacketType    = errors.New("unexpected packet type")
+)
+
+const (
+	// HeaderSize is the size of the packet header: version, type and payload
+	// length.
+	HeaderSize = 1 + 1 + 2
+	// MaxPayloadSize is the largest payload the length of the header can
+	// describe.
+	MaxPayloadSize = math.MaxUint16
+	MaxPacketSize  = HeaderSize + MaxPayloadSize
+)
 
 type PacketProtocolVersion uint8
 
+// ProtocolVersion is bumped when packets change in a way peers of the previous
+// version cannot read. Version 2 made ping and pong carry nonces and
+// timestamps.
 const (
-	ProtocolVersion PacketProtocolVersion = 1
+	ProtocolVersion PacketProtocolVersion = 2
 )
 
 type PacketType uint8
@@ -21,6 +42,12 @@ const (
 	PacketTypeMessage
 	PacketTypePing
 	PacketTypePong
+	PacketTypeMessageEdit
+	PacketTypeMessageDelete
+	PacketTypeReaction
+	PacketTypeTyping
+	PacketTypeReadMarker
+	PacketTypeFileOffer
 )
 
 type PacketHeader struct {
@@ -64,6 +91,12 @@ func PacketFromBytes(data []byte) (*Packet, error) {
 		return pkt, err
 	}
 
+	// checked before allocating the payload, so the length cannot be used to
+	// make the reader allocate more than it received
+	if int(pkt.Header.Len) != buf.Len() {
+		return pkt, ErrInvalidPacketLength
+	}
+
 	pkt.Payload = make([]byte, pkt.Header.Len)
 	if err := binary.Read(buf, binary.BigEndian, &pkt.Payload); err != nil {
 		return pkt, err
@@ -73,7 +106,15 @@ func PacketFromBytes(data []byte) (*Packet, error) {
 }
 
 func (pkt *Packet) ToBinary() ([]byte, error) {
+	if len(pkt.Payload) > MaxPayloadSize {
+		return nil, ErrPayloadTooLarge
+	}
+	if int(pkt.Header.Len) != len(pkt.Payload) {
+		return nil, ErrInvalidPacketLength
+	}
+
 	var buf bytes.Buffer
+	buf.Grow(HeaderSize + len(pkt.Payload))
 
 	if err := binary.Write(&buf, binary.BigEndian, pkt.Header.Version); err != nil {
 		return nil, err
@@ -93,3 +134,14 @@ func (pkt *Packet) ToBinary() ([]byte, error) {
 
 	return buf.Bytes(), nil
 }
+
+// decodePayload decodes the JSON payload of a packet of the given type.
+func decodePayload(pkt *Packet, pktType PacketType, v any) error {
+	if pkt.Header.PacketType != pktType {
+		return fmt.Errorf("%w: got %d, want %d", ErrUnexpectedPacketType, pkt.Header.PacketType, pktType)
+	}
+	if int(pkt.Header.Len) != len(pkt.Payload) {
+		return ErrInvalidPacketLength
+	}
+	return json.Unmarshal(pkt.Payload, v)
+}
@@ -30,3 +30,23 @@ func TestPacketFromBytes(t *testing.T) {
 	assert.Equal(t, originalPacket.Header.Len, parsedPacket.Header.Len)
 	assert.Equal(t, originalPacket.Payload, parsedPacket.Payload)
 }
+
+func TestPacketFromBytesLength(t *testing.T) {
+	data, err := NewPacket(PacketTypeMessage, []byte("hello")).ToBinary()
+	assert.Nil(t, err)
+
+	// a length larger than the payload received must not be trusted
+	_, err = PacketFromBytes([]byte{byte(ProtocolVersion), byte(PacketTypeMessage), 0xff, 0xff, 'h', 'i'})
+	assert.ErrorIs(t, err, ErrInvalidPacketLength)
+
+	_, err = PacketFromBytes(data[:len(data)-1])
+	assert.ErrorIs(t, err, ErrInvalidPacketLength)
+
+	_, err = PacketFromBytes(append(data, '!'))
+	assert.ErrorIs(t, err, ErrInvalidPacketLength)
+}
+
+func TestPacketToBinaryTooLarge(t *testing.T) {
+	_, err := NewPacket(PacketTypeMessage, make([]byte, MaxPayloadSize+1)).ToBinary()
+	assert.ErrorIs(t, err, ErrPayloadTooLarge)
+}
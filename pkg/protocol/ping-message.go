@@ -1,13 +1,30 @@
 package protocol
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"time"
 
+	"github.com/jnaraujo/letschat/pkg/secure"
+)
+
+// PingMessage is sent by either side of a connection to measure the round-trip
+// time. The receiver must answer with a PongMessage echoing the nonce and
+// SentAt, so the sender only ever compares timestamps from its own clock.
 type PingMessage struct {
+	Nonce  string    `json:"nonce"`
+	SentAt time.Time `json:"sent_at"`
+}
+
+func NewPingMessage() PingMessage {
+	return PingMessage{
+		Nonce:  secure.GenerateRandomString(12),
+		SentAt: time.Now(),
+	}
 }
 
 func PingMessageFromPacket(pkt *Packet) (PingMessage, error) {
 	var msg PingMessage
-	if err := json.Unmarshal(pkt.Payload, &msg); err != nil {
+	if err := decodePayload(pkt, PacketTypePing, &msg); err != nil {
 		return msg, err
 	}
 	return msg, nil
@@ -20,3 +37,36 @@ func (msg PingMessage) ToPacket() *Packet {
 	}
 	return NewPacket(PacketTypePing, payload)
 }
+
+// Pong builds the answer to this ping.
+func (msg PingMessage) Pong() PongMessage {
+	return PongMessage{
+		Nonce:      msg.Nonce,
+		SentAt:     msg.SentAt,
+		ReceivedAt: time.Now(),
+	}
+}
+
+type PongMessage struct {
+	Nonce  string    `json:"nonce"`
+	SentAt time.Time `json:"sent_at"`
+	// ReceivedAt is the responder's clock. It is informative only and must not
+	// be used to compute the round-trip time.
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+func PongMessageFromPacket(pkt *Packet) (PongMessage, error) {
+	var msg PongMessage
+	if err := decodePayload(pkt, PacketTypePong, &msg); err != nil {
+		return msg, err
+	}
+	return msg, nil
+}
+
+func (msg PongMessage) ToPacket() *Packet {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+	return NewPacket(PacketTypePong, payload)
+}
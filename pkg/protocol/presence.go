@@ -0,0 +1,49 @@
+package protocol
+
+import (
+	"encoding/json"
+
+	"github.com/jnaraujo/letschat/pkg/account"
+	"github.com/jnaraujo/letschat/pkg/id"
+)
+
+type Status string
+
+const (
+	StatusOnline       Status = "online"
+	StatusAway         Status = "away"
+	StatusDoNotDisturb Status = "dnd"
+)
+
+func (s Status) IsValid() bool {
+	switch s {
+	case StatusOnline, StatusAway, StatusDoNotDisturb:
+		return true
+	}
+	return false
+}
+
+// TypingMessage tells the room that an account started or stopped typing.
+// Clients send it with Typing set; the server fills the remaining fields before
+// sending it to the room.
+type TypingMessage struct {
+	RoomID  id.ID            `json:"room_id,omitempty"`
+	Account *account.Account `json:"account,omitempty"`
+	Typing  bool             `json:"typing"`
+}
+
+func TypingMessageFromPacket(pkt *Packet) (TypingMessage, error) {
+	var msg TypingMessage
+	if err := decodePayload(pkt, PacketTypeTyping, &msg); err != nil {
+		return msg, err
+	}
+	return msg, nil
+}
+
+func (msg TypingMessage) ToPacket() *Packet {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+	return NewPacket(PacketTypeTyping, payload)
+}
@@ -0,0 +1,87 @@
+package protocol
+
+import (
+	"encoding/json"
+	"slices"
+
+	"github.com/jnaraujo/letschat/pkg/account"
+	"github.com/jnaraujo/letschat/pkg/id"
+)
+
+// Reaction toggles an emoji reaction on a message. Clients send it with the
+// MessageID and the Emoji; the server fills the remaining fields before sending
+// it to the room.
+type Reaction struct {
+	MessageID id.ID            `json:"message_id"`
+	RoomID    id.ID            `json:"room_id,omitempty"`
+	Emoji     string           `json:"emoji"`
+	Account   *account.Account `json:"account,omitempty"`
+	// Added tells whether the reaction was added or removed.
+	Added bool `json:"added"`
+}
+
+func ReactionFromPacket(pkt *Packet) (Reaction, error) {
+	var msg Reaction
+	if err := decodePayload(pkt, PacketTypeReaction, &msg); err != nil {
+		return msg, err
+	}
+	return msg, nil
+}
+
+func (msg Reaction) ToPacket() *Packet {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+	return NewPacket(PacketTypeReaction, payload)
+}
+
+// Apply adds or removes the reaction from chatMsg.
+func (msg Reaction) Apply(chatMsg *ChatMessage) {
+	if msg.Account == nil {
+		return
+	}
+	chatMsg.SetReaction(msg.Emoji, msg.Account.ID, msg.Added)
+}
+
+func (msg ChatMessage) HasReaction(emoji string, accountID id.ID) bool {
+	return slices.Contains(msg.Reactions[emoji], accountID)
+}
+
+// ReactionCount returns the number of reactions to the message, counting every
+// account of every emoji.
+func (msg ChatMessage) ReactionCount() int {
+	n := 0
+	for _, accounts := range msg.Reactions {
+		n += len(accounts)
+	}
+	return n
+}
+
+// SetReaction adds or removes the reaction of an account. The reactions are
+// copied before being changed, so copies of the message are never affected.
+func (msg *ChatMessage) SetReaction(emoji string, accountID id.ID, on bool) {
+	reactions := make(map[string][]id.ID, len(msg.Reactions)+1)
+	for e, accounts := range msg.Reactions {
+		reactions[e] = slices.Clone(accounts)
+	}
+
+	accounts := reactions[emoji]
+	i := slices.Index(accounts, accountID)
+	switch {
+	case on && i == -1:
+		reactions[emoji] = append(accounts, accountID)
+	case !on && i != -1:
+		accounts = slices.Delete(accounts, i, i+1)
+		if len(accounts) == 0 {
+			delete(reactions, emoji)
+		} else {
+			reactions[emoji] = accounts
+		}
+	}
+
+	if len(reactions) == 0 {
+		reactions = nil
+	}
+	msg.Reactions = reactions
+}
@@ -0,0 +1,37 @@
+package protocol
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/jnaraujo/letschat/pkg/account"
+	"github.com/jnaraujo/letschat/pkg/id"
+)
+
+// ReadMarker tells the server the last message an account has seen in a room.
+// Clients send it with the MessageID and whether a read receipt should be shown
+// to the room; the server fills the remaining fields before sending the
+// receipt.
+type ReadMarker struct {
+	MessageID id.ID            `json:"message_id"`
+	RoomID    id.ID            `json:"room_id,omitempty"`
+	Account   *account.Account `json:"account,omitempty"`
+	Receipt   bool             `json:"receipt"`
+	ReadAt    time.Time        `json:"read_at"`
+}
+
+func ReadMarkerFromPacket(pkt *Packet) (ReadMarker, error) {
+	var msg ReadMarker
+	if err := decodePayload(pkt, PacketTypeReadMarker, &msg); err != nil {
+		return msg, err
+	}
+	return msg, nil
+}
+
+func (msg ReadMarker) ToPacket() *Packet {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+	return NewPacket(PacketTypeReadMarker, payload)
+}
@@ -0,0 +1,55 @@
+package sanitize
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// confusables maps characters to the ASCII letter they look like. It is a small
+// subset of the Unicode confusables list, covering the characters most used to
+// impersonate someone.
+var confusables = map[rune]rune{
+	'0': 'o', '1': 'l', 'I': 'l', '|': 'l',
+
+	// Cyrillic
+	'а': 'a', 'в': 'b', 'е': 'e', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o',
+	'р': 'p', 'с': 'c', 'т': 't', 'у': 'y', 'х': 'x', 'і': 'i', 'ј': 'j',
+	'ѕ': 's', 'ԁ': 'd', 'һ': 'h', 'ӏ': 'l', 'ԛ': 'q', 'ԝ': 'w',
+	'А': 'a', 'В': 'b', 'Е': 'e', 'К': 'k', 'М': 'm', 'Н': 'h', 'О': 'o',
+	'Р': 'p', 'С': 'c', 'Т': 't', 'У': 'y', 'Х': 'x', 'І': 'l', 'Ј': 'j',
+	'Ѕ': 's',
+
+	// Greek
+	'α': 'a', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p', 'τ': 't',
+	'υ': 'u', 'χ': 'x', 'Α': 'a', 'Β': 'b', 'Ε': 'e', 'Ζ': 'z', 'Η': 'h',
+	'Ι': 'l', 'Κ': 'k', 'Μ': 'm', 'Ν': 'n', 'Ο': 'o', 'Ρ': 'p', 'Τ': 't',
+	'Υ': 'y', 'Χ': 'x',
+}
+
+// Confusable reports whether two texts look alike, so one could be used to
+// impersonate the other. Case, accents and compatibility variants like
+// full-width letters are ignored.
+func Confusable(a, b string) bool {
+	// an uppercase I looks like a lowercase l, but ignoring case would make it
+	// equal to a lowercase i, so the texts are compared both ways
+	return skeleton(a) == skeleton(b) ||
+		skeleton(strings.ToLower(a)) == skeleton(strings.ToLower(b))
+}
+
+// skeleton returns a form of the text in which characters that look alike are
+// the same.
+func skeleton(s string) string {
+	var b strings.Builder
+	for _, r := range norm.NFKD.String(s) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if c, ok := confusables[r]; ok {
+			r = c
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return strings.ReplaceAll(b.String(), "rn", "m")
+}
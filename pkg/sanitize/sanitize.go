@@ -0,0 +1,171 @@
+// Package sanitize validates and cleans the text users send, like usernames,
+// room names and messages, before it is shown to other users.
+package sanitize
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+var (
+	ErrInvalidUTF8      = errors.New("text is not valid UTF-8")
+	ErrInvalidCharacter = errors.New("text has characters that are not allowed")
+	ErrMixedScripts     = errors.New("text mixes letters of different alphabets")
+)
+
+const esc = '\x1b'
+
+// Text cleans multi-line text, like the content of a message. Terminal escape
+// sequences and control characters other than newlines and tabs are removed,
+// and the result is NFC normalized.
+func Text(s string) (string, error) {
+	if !utf8.ValidString(s) {
+		return "", ErrInvalidUTF8
+	}
+	s = stripEscapes(s)
+	s = strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' {
+			return r
+		}
+		if isControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+	return norm.NFC.String(s), nil
+}
+
+// Line cleans single-line text, like a room name. It works like Text, but
+// newlines and tabs are removed as well.
+func Line(s string) (string, error) {
+	s, err := Text(s)
+	if err != nil {
+		return "", err
+	}
+	return strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' {
+			return -1
+		}
+		return r
+	}, s), nil
+}
+
+// Username validates a username and returns its NFC normalized form. Only
+// letters, numbers, combining marks, '_', '-' and '.' are allowed, and letters
+// of the easily confused Latin, Greek and Cyrillic alphabets cannot be mixed.
+func Username(s string) (string, error) {
+	if !utf8.ValidString(s) {
+		return "", ErrInvalidUTF8
+	}
+	s = norm.NFC.String(s)
+
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsNumber(r) && !unicode.IsMark(r) &&
+			r != '_' && r != '-' && r != '.' {
+			return "", ErrInvalidCharacter
+		}
+	}
+	if mixesScripts(s) {
+		return "", ErrMixedScripts
+	}
+	return s, nil
+}
+
+// Length returns the number of characters of the text.
+func Length(s string) int {
+	return utf8.RuneCountInString(s)
+}
+
+// isControl reports whether r is a control character, a character that changes
+// the direction of the text or an invisible space. Joiners are kept, as emoji
+// and some scripts need them.
+func isControl(r rune) bool {
+	switch r {
+	case '\u00ad', '\u200b', '\u2060', '\ufeff':
+		return true
+	}
+	return unicode.IsControl(r) || unicode.Is(unicode.Bidi_Control, r)
+}
+
+// stripEscapes removes terminal escape sequences: CSI sequences like colors
+// and cursor movements, OSC sequences like window titles and hyperlinks, the
+// DCS, SOS, PM and APC strings and two-character escapes.
+func stripEscapes(s string) string {
+	if !strings.ContainsRune(s, esc) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		if s[i] != esc {
+			b.WriteByte(s[i])
+			i++
+			continue
+		}
+		i += escapeLength(s[i:])
+	}
+	return b.String()
+}
+
+// escapeLength returns the length of the escape sequence at the start of s,
+// which starts with ESC. Unterminated sequences run until the end of s.
+func escapeLength(s string) int {
+	if len(s) == 1 {
+		return 1
+	}
+
+	switch s[1] {
+	case '[':
+		// parameters and intermediate bytes followed by a final byte
+		for i := 2; i < len(s); i++ {
+			if s[i] >= 0x40 && s[i] <= 0x7e {
+				return i + 1
+			}
+			if s[i] < 0x20 || s[i] > 0x3f {
+				// not a valid CSI sequence, drop only the introducer
+				return 2
+			}
+		}
+		return len(s)
+	case ']', 'P', 'X', '^', '_':
+		// strings terminated by BEL or ST (ESC \)
+		for i := 2; i < len(s); i++ {
+			if s[i] == '\a' {
+				return i + 1
+			}
+			if s[i] == esc && i+1 < len(s) && s[i+1] == '\\' {
+				return i + 2
+			}
+		}
+		return len(s)
+	}
+
+	_, size := utf8.DecodeRuneInString(s[1:])
+	return 1 + size
+}
+
+var confusableScripts = []*unicode.RangeTable{
+	unicode.Latin,
+	unicode.Greek,
+	unicode.Cyrillic,
+}
+
+func mixesScripts(s string) bool {
+	var found *unicode.RangeTable
+	for _, r := range s {
+		for _, script := range confusableScripts {
+			if !unicode.Is(script, r) {
+				continue
+			}
+			if found != nil && found != script {
+				return true
+			}
+			found = script
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package sanitize
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestText(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain", "hello, world", "hello, world"},
+		{"keeps newlines and tabs", "a\n\tb", "a\n\tb"},
+		{"colors", "\x1b[31mred\x1b[0m", "red"},
+		{"cursor movement", "a\x1b[2J\x1b[1;1Hb", "ab"},
+		{"window title", "\x1b]0;pwned\atext", "text"},
+		{"hyperlink", "\x1b]8;;https://a.b\x1b\\link\x1b]8;;\x1b\\", "link"},
+		{"two-character escape", "a\x1bcb", "ab"},
+		{"unterminated", "a\x1b[31", "a"},
+		{"controls", "a\rb\x00c\x07d", "abcd"},
+		{"C1 controls", "a\u009b31mb", "a31mb"},
+		{"bidi override", "a‮b", "ab"},
+		{"keeps joiners", "👨‍👩", "👨‍👩"},
+		{"NFC", "café", "café"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Text(tt.in)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err := Text("a\xffb")
+	assert.ErrorIs(t, err, ErrInvalidUTF8)
+}
+
+func TestLine(t *testing.T) {
+	got, err := Line("a\nb\tc")
+	require.NoError(t, err)
+	assert.Equal(t, "abc", got)
+}
+
+func TestUsername(t *testing.T) {
+	for _, name := range []string{"alice", "José", "Дмитрий", "山田太郎", "a_b-c.d"} {
+		_, err := Username(name)
+		assert.NoError(t, err, name)
+	}
+
+	got, err := Username("José")
+	require.NoError(t, err)
+	assert.Equal(t, "José", got)
+
+	_, err = Username("al ice")
+	assert.ErrorIs(t, err, ErrInvalidCharacter)
+	_, err = Username("\x1b[31malice")
+	assert.ErrorIs(t, err, ErrInvalidCharacter)
+	_, err = Username("pаypal") // Cyrillic a
+	assert.ErrorIs(t, err, ErrMixedScripts)
+	_, err = Username("a\xffb")
+	assert.ErrorIs(t, err, ErrInvalidUTF8)
+}
+
+func TestConfusable(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"alice", "ALICE", true},
+		{"alice", "aIice", true},
+		{"bob", "b0b", true},
+		{"modern", "rnodern", true},
+		{"jose", "José", true},
+		{"alice", "ａｌｉｃｅ", true},
+		{"copy", "сору", true}, // Cyrillic
+		{"alice", "bob", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, Confusable(tt.a, tt.b), "%s %s", tt.a, tt.b)
+	}
+}
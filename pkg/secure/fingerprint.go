@@ -0,0 +1,14 @@
+package secure
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+)
+
+// Fingerprint returns the SHA-256 hash of the public key of the certificate, in
+// hex. It does not change when the certificate is renewed with the same key.
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return hex.EncodeToString(sum[:])
+}
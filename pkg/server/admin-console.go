@@ -0,0 +1,126 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/jnaraujo/letschat/pkg/account"
+	"github.com/jnaraujo/letschat/pkg/protocol"
+)
+
+var errConsoleWriteOnly = errors.New("console connections are write-only")
+
+// RunAdminSocket serves the admin console on a Unix socket that only the user
+// running the server can connect to, e.g. with "nc -U <path>". Accounts only
+// live as long as their connection, so the console is also where clients are
+// granted the admin permission.
+func (s *Server) RunAdminSocket(path string) error {
+	// a socket left by a server that did not stop cleanly
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		l.Close()
+		return err
+	}
+	return s.ServeAdmin(l)
+}
+
+// ServeAdmin runs the admin console on the listener. Each line received is an
+// admin command, like "kick <account id>", and its output is written back.
+func (s *Server) ServeAdmin(l net.Listener) error {
+	defer l.Close()
+	// stops accepting consoles on shutdown
+	stop := context.AfterFunc(s.ctx, func() { l.Close() })
+	defer stop()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go s.handleAdminConsole(conn)
+	}
+}
+
+func (s *Server) handleAdminConsole(conn net.Conn) {
+	client := NewClient(s.ctx, account.NewAccount("console"), &consoleConnection{conn: conn})
+	defer client.Disconnect()
+	// the blocked scanner returns once the connection is closed
+	context.AfterFunc(client.Context(), func() { conn.Close() })
+	client.SetAdmin(true)
+	slog.Info("admin console connected")
+
+	fmt.Fprintln(conn, "LetsChat admin console. Type help to see the commands or quit to leave.")
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch line {
+		case "":
+			continue
+		case "quit", "exit":
+			return
+		}
+		s.runAdminCommand(client, line)
+	}
+}
+
+// consoleConnection writes the command responses sent to an admin console as
+// plain text.
+type consoleConnection struct {
+	conn  net.Conn
+	mutex sync.Mutex
+}
+
+func (cc *consoleConnection) Write(data []byte) error {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+
+	_, err := cc.conn.Write(data)
+	return err
+}
+
+func (cc *consoleConnection) Read() ([]byte, error) {
+	return nil, errConsoleWriteOnly
+}
+
+func (cc *consoleConnection) WritePacket(pkt *protocol.Packet) error {
+	if pkt.Header.PacketType != protocol.PacketTypeMessage {
+		return nil
+	}
+	msg, err := protocol.ChatMessageFromPacket(pkt)
+	if err != nil {
+		return err
+	}
+	return cc.Write([]byte(msg.Content + "\n"))
+}
+
+func (cc *consoleConnection) ReadPacket() (*protocol.Packet, error) {
+	return nil, errConsoleWriteOnly
+}
+
+func (cc *consoleConnection) RemoteAddr() string {
+	return "admin console"
+}
+
+func (cc *consoleConnection) Ping() error {
+	return nil
+}
+
+func (cc *consoleConnection) Close() error {
+	return cc.conn.Close()
+}
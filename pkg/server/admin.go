@@ -0,0 +1,279 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jnaraujo/letschat/pkg/audit"
+	"github.com/jnaraujo/letschat/pkg/id"
+	"github.com/jnaraujo/letschat/pkg/protocol"
+	"github.com/jnaraujo/letschat/pkg/sanitize"
+	"github.com/jnaraujo/letschat/pkg/utils"
+)
+
+var (
+	ErrNotAdmin            = errors.New("only server administrators can do this")
+	ErrInvalidAdminToken   = errors.New("invalid admin token")
+	ErrClientNotFound      = errors.New("client not found")
+	ErrCannotDeleteDefault = errors.New("the default room cannot be deleted")
+)
+
+// adminCommands are run with "/admin <command>" by clients granted admin from
+// the admin console or logged in with the admin token, or directly in the
+// console. Unknown commands, like help, list the admin commands.
+var adminCommands = map[string]CommandHandler{
+	"rooms":       adminRoomsCommand,
+	"clients":     adminClientsCommand,
+	"announce":    adminAnnounceCommand,
+	"kick":        adminKickCommand,
+	"delete-room": adminDeleteRoomCommand,
+	"stats":       adminStatsCommand,
+	"grant":       adminGrantCommand,
+	"revoke":      adminRevokeCommand,
+}
+
+func adminCommand(props *CommandProps) {
+	if token, ok := strings.CutPrefix(props.Msg.Content, "admin login "); ok {
+		adminLogin(props, strings.TrimSpace(token))
+		return
+	}
+	if !props.MessageAuthor.IsAdmin() {
+		sendCommandError(props.MessageAuthor, "Failed to run admin command", ErrNotAdmin)
+		return
+	}
+	_, line, _ := strings.Cut(props.Msg.Content, " ")
+	props.Server.runAdminCommand(props.MessageAuthor, strings.TrimSpace(line))
+}
+
+// adminLogin makes the client an administrator if it knows the admin token.
+// Account IDs change on every connection, so the token is what identifies the
+// operators of the server.
+func adminLogin(props *CommandProps, token string) {
+	s, client := props.Server, props.MessageAuthor
+	if s.adminToken == "" || !tokenEquals(s.adminToken, token) {
+		s.audit(audit.AuthFailure, client, nil, client.Account().Username, "admin login")
+		sendCommandError(client, "Failed to log in as administrator", ErrInvalidAdminToken)
+		return
+	}
+	client.SetAdmin(true)
+	s.audit(audit.AuthSuccess, client, nil, client.Account().Username, "admin login")
+	sendCommandReply(client, "You are now a server administrator. See the commands with: /admin help")
+}
+
+func (s *Server) runAdminCommand(client *Client, line string) {
+	name, _, _ := strings.Cut(line, " ")
+	command, ok := adminCommands[name]
+	if !ok {
+		sendCommandReply(client,
+			"Admin commands: "+strings.Join(slices.Sorted(maps.Keys(adminCommands)), ", "))
+		return
+	}
+
+	slog.Info("admin command",
+		"by", client.Account().Username,
+		"by-id", client.Account().ID,
+		"command", line,
+	)
+	s.audit(audit.AdminCommand, client, nil, name, line)
+
+	msg := protocol.ChatMessage{
+		Author:    client.Account(),
+		Content:   line,
+		IsCommand: true,
+		CreatedAt: time.Now(),
+	}
+	command(&CommandProps{
+		MessageAuthor: client,
+		Msg:           &msg,
+		Server:        s,
+	})
+}
+
+// findClient looks for a connected client in every room.
+func (s *Server) findClient(accountID id.ID) *Client {
+	for _, room := range s.rooms.List() {
+		if client := room.Clients.Find(accountID); client != nil {
+			return client
+		}
+	}
+	return nil
+}
+
+func sendCommandReply(client *Client, content string) {
+	client.Conn.WritePacket(protocol.NewCommandChatMessage(content, time.Now()).ToPacket())
+}
+
+func adminRoomsCommand(props *CommandProps) {
+	rooms := props.Server.rooms.List()
+	slices.SortFunc(rooms, func(a, b *Room) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	var res strings.Builder
+	fmt.Fprintf(&res, "==== %d Room%s ====", len(rooms), utils.Plural(len(rooms)))
+	for _, room := range rooms {
+		owner := "-"
+		if room.Owner != nil {
+			owner = fmt.Sprintf("%s (%s)", room.Owner.Username, room.Owner.ID)
+		}
+		fmt.Fprintf(&res, "\n%s %s: %d client%s, owner %s",
+			room.ID, room.Name, len(room.Clients.List()), utils.Plural(len(room.Clients.List())), owner)
+	}
+	sendCommandReply(props.MessageAuthor, res.String())
+}
+
+func adminClientsCommand(props *CommandProps) {
+	var clients []*Client
+	for _, room := range props.Server.rooms.List() {
+		clients = append(clients, room.Clients.List()...)
+	}
+	slices.SortFunc(clients, func(a, b *Client) int {
+		return a.JoinedAt.Compare(b.JoinedAt)
+	})
+
+	var res strings.Builder
+	fmt.Fprintf(&res, "==== %d Client%s ====", len(clients), utils.Plural(len(clients)))
+	for _, client := range clients {
+		account := client.Account()
+		roomName := "-"
+		if room := props.Server.rooms.Find(client.RoomID()); room != nil {
+			roomName = room.Name
+		}
+		fmt.Fprintf(&res, "\n%s %s in %s from %s, connected for %s, latency %s",
+			account.ID, account.Username, roomName, client.Conn.RemoteAddr(),
+			time.Since(client.JoinedAt).Round(time.Second), utils.FormatLatency(client.Latency.RTT()))
+		if client.IsAdmin() {
+			res.WriteString(" (admin)")
+		}
+	}
+	sendCommandReply(props.MessageAuthor, res.String())
+}
+
+func adminAnnounceCommand(props *CommandProps) {
+	_, text, _ := strings.Cut(props.Msg.Content, " ")
+	text, err := sanitize.Text(strings.TrimSpace(text))
+	if err != nil || text == "" {
+		sendCommandReply(props.MessageAuthor, "Usage: announce <text>")
+		return
+	}
+
+	rooms := props.Server.rooms.List()
+	for _, room := range rooms {
+		room.Broadcast(protocol.NewServerChatMessage("Announcement: "+text, room.ChatRoom(), time.Now()))
+	}
+	sendCommandReply(props.MessageAuthor,
+		fmt.Sprintf("Announcement sent to %d room%s.", len(rooms), utils.Plural(len(rooms))))
+}
+
+func adminKickCommand(props *CommandProps) {
+	words := strings.SplitN(props.Msg.Content, " ", 3)
+	if len(words) < 2 {
+		sendCommandReply(props.MessageAuthor, "Usage: kick <account id> [reason]")
+		return
+	}
+
+	client := props.Server.findClient(id.ID(words[1]))
+	if client == nil {
+		sendCommandError(props.MessageAuthor, "Failed to kick client", ErrClientNotFound)
+		return
+	}
+
+	content := "You were disconnected by an administrator."
+	if len(words) == 3 {
+		content += " Reason: " + words[2]
+	}
+	client.Conn.WritePacket(protocol.NewCommandChatMessage(content, time.Now()).ToPacket())
+	// closing the connection ends its read loop, which removes the client
+	client.Disconnect()
+
+	sendCommandReply(props.MessageAuthor,
+		fmt.Sprintf("%s (%s) was disconnected.", client.Account().Username, client.Account().ID))
+}
+
+func adminDeleteRoomCommand(props *CommandProps) {
+	words := strings.Fields(props.Msg.Content)
+	if len(words) != 2 {
+		sendCommandReply(props.MessageAuthor, "Usage: delete-room <room id>")
+		return
+	}
+
+	s := props.Server
+	roomID := id.ID(words[1])
+	if roomID == defaultRoomID {
+		sendCommandError(props.MessageAuthor, "Failed to delete room", ErrCannotDeleteDefault)
+		return
+	}
+	room := s.rooms.Find(roomID)
+	if room == nil {
+		sendCommandError(props.MessageAuthor, "Failed to delete room", ErrRoomNotFound)
+		return
+	}
+
+	room.Broadcast(protocol.NewServerChatMessage(
+		"This room was deleted by an administrator.", room.ChatRoom(), time.Now(),
+	))
+	for _, client := range room.Clients.List() {
+		if err := s.addClientToRoom(client, defaultRoomID); err != nil {
+			client.Disconnect()
+		}
+	}
+	s.rooms.Remove(room.ID)
+	s.audit(audit.RoomDelete, props.MessageAuthor, room, room.Name, "")
+	// clients that joined while the others were moved
+	for _, client := range room.Clients.List() {
+		client.Disconnect()
+	}
+	for _, hook := range room.Webhooks.List() {
+		room.Webhooks.Remove(hook.ID)
+	}
+
+	sendCommandReply(props.MessageAuthor, fmt.Sprintf("Room %q deleted.", room.Name))
+}
+
+func adminStatsCommand(props *CommandProps) {
+	stats := props.Server.Stats()
+	sendCommandReply(props.MessageAuthor, fmt.Sprintf(
+		"Uptime %s, %d room%s, %d client%s, %d goroutines, %s of heap",
+		stats.Uptime.Round(time.Second),
+		stats.Rooms, utils.Plural(stats.Rooms),
+		stats.Clients, utils.Plural(stats.Clients),
+		stats.Goroutines,
+		utils.FormatSize(int64(stats.HeapAlloc)),
+	))
+}
+
+func adminGrantCommand(props *CommandProps) {
+	setAdmin(props, true)
+}
+
+func adminRevokeCommand(props *CommandProps) {
+	setAdmin(props, false)
+}
+
+func setAdmin(props *CommandProps, admin bool) {
+	words := strings.Fields(props.Msg.Content)
+	if len(words) != 2 {
+		sendCommandReply(props.MessageAuthor, fmt.Sprintf("Usage: %s <account id>", words[0]))
+		return
+	}
+
+	client := props.Server.findClient(id.ID(words[1]))
+	if client == nil {
+		sendCommandError(props.MessageAuthor, "Failed to change admin permission", ErrClientNotFound)
+		return
+	}
+	client.SetAdmin(admin)
+
+	if admin {
+		sendCommandReply(client, "You are now a server administrator. See the commands with: /admin help")
+		sendCommandReply(props.MessageAuthor, fmt.Sprintf("%s is now an administrator.", client.Account().Username))
+	} else {
+		sendCommandReply(client, "You are no longer a server administrator.")
+		sendCommandReply(props.MessageAuthor, fmt.Sprintf("%s is no longer an administrator.", client.Account().Username))
+	}
+}
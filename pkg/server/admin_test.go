@@ -0,0 +1,133 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testAdminToken = "admin-token"
+
+// newAdmin connects a client and logs it in as administrator.
+func newAdmin(t *testing.T, h *testHarness, username string) *testClient {
+	t.Helper()
+
+	admin := h.Connect(username)
+	admin.SendCommand("admin login " + testAdminToken)
+	require.Contains(t, admin.ExpectCommandResponse().Content, "You are now a server administrator")
+	return admin
+}
+
+func TestAdminLogin(t *testing.T) {
+	h := newTestHarness(t, WithAdminToken(testAdminToken))
+	alice := h.Connect("alice")
+
+	alice.SendCommand("admin stats")
+	assert.Contains(t, alice.ExpectCommandResponse().Content, ErrNotAdmin.Error())
+
+	alice.SendCommand("admin login wrong")
+	assert.Contains(t, alice.ExpectCommandResponse().Content, ErrInvalidAdminToken.Error())
+	assert.False(t, h.server.findClient(alice.Account.ID).IsAdmin())
+
+	alice.SendCommand("admin login " + testAdminToken)
+	alice.ExpectCommandResponse()
+	assert.True(t, h.server.findClient(alice.Account.ID).IsAdmin())
+
+	alice.SendCommand("admin stats")
+	assert.Contains(t, alice.ExpectCommandResponse().Content, "Uptime")
+}
+
+func TestAdminLoginWithoutToken(t *testing.T) {
+	h := newTestHarness(t)
+	alice := h.Connect("alice")
+
+	// without a configured token nobody can log in
+	alice.SendCommand("admin login ")
+	assert.Contains(t, alice.ExpectCommandResponse().Content, ErrInvalidAdminToken.Error())
+	assert.False(t, h.server.findClient(alice.Account.ID).IsAdmin())
+}
+
+func TestAdminCommands(t *testing.T) {
+	h := newTestHarness(t, WithAdminToken(testAdminToken))
+	admin := newAdmin(t, h, "admin")
+	bobby := h.Connect("bobby")
+	admin.ExpectServerMessage()
+
+	t.Run("rooms", func(t *testing.T) {
+		admin.SendCommand("admin rooms")
+		res := admin.ExpectCommandResponse().Content
+		assert.Contains(t, res, "1 Room")
+		assert.Contains(t, res, "ALL: 2 clients")
+	})
+
+	t.Run("clients", func(t *testing.T) {
+		admin.SendCommand("admin clients")
+		res := admin.ExpectCommandResponse().Content
+		assert.Contains(t, res, string(bobby.Account.ID)+" bobby in ALL")
+		assert.Contains(t, res, string(admin.Account.ID)+" admin in ALL")
+		assert.Contains(t, res, "(admin)")
+	})
+
+	t.Run("announce", func(t *testing.T) {
+		admin.SendCommand("admin announce maintenance soon")
+		assert.Equal(t, "Announcement: maintenance soon", bobby.ExpectServerMessage().Content)
+		assert.Equal(t, "Announcement: maintenance soon", admin.ExpectServerMessage().Content)
+		assert.Contains(t, admin.ExpectCommandResponse().Content, "sent to 1 room")
+	})
+
+	t.Run("grant and revoke", func(t *testing.T) {
+		admin.SendCommand("admin grant " + string(bobby.Account.ID))
+		bobby.ExpectCommandResponse()
+		admin.ExpectCommandResponse()
+		assert.True(t, h.server.findClient(bobby.Account.ID).IsAdmin())
+
+		admin.SendCommand("admin revoke " + string(bobby.Account.ID))
+		bobby.ExpectCommandResponse()
+		admin.ExpectCommandResponse()
+		assert.False(t, h.server.findClient(bobby.Account.ID).IsAdmin())
+	})
+
+	t.Run("unknown client", func(t *testing.T) {
+		admin.SendCommand("admin kick nobody")
+		assert.Contains(t, admin.ExpectCommandResponse().Content, ErrClientNotFound.Error())
+	})
+}
+
+func TestAdminKick(t *testing.T) {
+	h := newTestHarness(t, WithAdminToken(testAdminToken))
+	admin := newAdmin(t, h, "admin")
+	bobby := h.Connect("bobby")
+	admin.ExpectServerMessage()
+
+	admin.SendCommand("admin kick " + string(bobby.Account.ID) + " spam")
+	assert.Contains(t, bobby.ExpectCommandResponse().Content, "Reason: spam")
+	bobby.ExpectClosed()
+	assert.Contains(t, admin.ExpectCommandResponse().Content, "was disconnected")
+	assert.Contains(t, admin.ExpectServerMessage().Content, "bobby")
+	assert.Nil(t, h.server.findClient(bobby.Account.ID))
+}
+
+func TestAdminDeleteRoom(t *testing.T) {
+	h := newTestHarness(t, WithAdminToken(testAdminToken))
+	admin := newAdmin(t, h, "admin")
+	bobby := h.Connect("bobby")
+	admin.ExpectServerMessage()
+	room := newTestRoom(t, h, bobby, "games")
+	bobby.SendCommand("join " + string(room.ID))
+	bobby.ExpectServerMessage()
+	admin.ExpectServerMessage()
+
+	admin.SendCommand("admin delete-room " + string(defaultRoomID))
+	assert.Contains(t, admin.ExpectCommandResponse().Content, ErrCannotDeleteDefault.Error())
+
+	admin.SendCommand("admin delete-room " + string(room.ID))
+	assert.Contains(t, bobby.ExpectServerMessage().Content, "deleted by an administrator")
+	// bobby is moved back to the default room
+	assert.Contains(t, bobby.ExpectServerMessage().Content, "bobby")
+	assert.Contains(t, admin.ExpectServerMessage().Content, "bobby")
+	assert.Contains(t, admin.ExpectCommandResponse().Content, `Room "games" deleted`)
+
+	assert.False(t, h.server.rooms.Has(room.ID))
+	assert.Equal(t, defaultRoomID, h.server.findClient(bobby.Account.ID).RoomID())
+}
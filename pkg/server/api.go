@@ -0,0 +1,206 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jnaraujo/letschat/pkg/account"
+	"github.com/jnaraujo/letschat/pkg/audit"
+	"github.com/jnaraujo/letschat/pkg/id"
+	"github.com/jnaraujo/letschat/pkg/protocol"
+)
+
+// maxAPIBodySize limits the JSON bodies accepted by the HTTP API.
+const maxAPIBodySize = 64 << 10
+
+var ErrInvalidAPIToken = errors.New("missing or invalid API token")
+
+type apiRoom struct {
+	ID      id.ID            `json:"id"`
+	Name    string           `json:"name"`
+	Owner   *account.Account `json:"owner,omitempty"`
+	Clients int              `json:"clients"`
+}
+
+type apiClient struct {
+	Account    *account.Account `json:"account"`
+	Status     protocol.Status  `json:"status"`
+	StatusText string           `json:"status_text,omitempty"`
+	JoinedAt   time.Time        `json:"joined_at"`
+}
+
+type apiNewRoom struct {
+	Name string `json:"name"`
+}
+
+type apiNewMessage struct {
+	Content string `json:"content"`
+	ReplyTo id.ID  `json:"reply_to,omitempty"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// WithBot lets a bot use the HTTP API with the token. Each bot gets its own
+// account, which authors the messages it posts.
+func WithBot(name, token string) Option {
+	return func(s *Server) {
+		if s.bots == nil {
+			s.bots = make(map[string]*account.Account)
+		}
+		s.bots[token] = account.NewBotAccount(name)
+	}
+}
+
+type apiHandler func(w http.ResponseWriter, r *http.Request, bot *account.Account)
+
+// withBot authenticates the request with the bearer token of a bot.
+func (s *Server) withBot(next apiHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		var bot *account.Account
+		// every token is compared, so the time taken does not tell which one
+		// almost matched
+		for botToken, botAccount := range s.bots {
+			if tokenEquals(botToken, token) {
+				bot = botAccount
+			}
+		}
+		if token == "" || bot == nil {
+			ev := audit.NewEvent(audit.AuthFailure, nil)
+			ev.IP = getRealIP(r)
+			ev.Detail = ErrInvalidAPIToken.Error()
+			s.auditLog.Log(ev)
+			writeAPIError(w, http.StatusUnauthorized, ErrInvalidAPIToken)
+			return
+		}
+
+		next(w, r, bot)
+	}
+}
+
+func (s *Server) handleAPIListRooms(w http.ResponseWriter, r *http.Request, bot *account.Account) {
+	rooms := s.rooms.List()
+	slices.SortFunc(rooms, func(a, b *Room) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	res := make([]apiRoom, 0, len(rooms))
+	for _, room := range rooms {
+		res = append(res, newAPIRoom(room))
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) handleAPICreateRoom(w http.ResponseWriter, r *http.Request, bot *account.Account) {
+	var req apiNewRoom
+	if err := readJSON(w, r, &req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	name, err := validateRoomName(req.Name)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	room := NewRoom(name, bot)
+	s.rooms.Add(room)
+	slog.Info("room created", "by", bot.Username, "room", room.Name, "id", room.ID)
+	ev := audit.NewEvent(audit.RoomCreate, bot)
+	ev.IP = getRealIP(r)
+	ev.RoomID = room.ID
+	ev.Target = room.Name
+	s.auditLog.Log(ev)
+
+	writeJSON(w, http.StatusCreated, newAPIRoom(room))
+}
+
+func (s *Server) handleAPIListClients(w http.ResponseWriter, r *http.Request, bot *account.Account) {
+	room := s.rooms.Find(id.ID(r.PathValue("id")))
+	if room == nil {
+		writeAPIError(w, http.StatusNotFound, ErrRoomNotFound)
+		return
+	}
+
+	clients := room.Clients.List()
+	slices.SortFunc(clients, func(a, b *Client) int {
+		return a.JoinedAt.Compare(b.JoinedAt)
+	})
+
+	res := make([]apiClient, 0, len(clients))
+	for _, client := range clients {
+		status, text := client.Presence.Status()
+		res = append(res, apiClient{
+			Account:    client.Account(),
+			Status:     status,
+			StatusText: text,
+			JoinedAt:   client.JoinedAt,
+		})
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) handleAPIPostMessage(w http.ResponseWriter, r *http.Request, bot *account.Account) {
+	room := s.rooms.Find(id.ID(r.PathValue("id")))
+	if room == nil {
+		writeAPIError(w, http.StatusNotFound, ErrRoomNotFound)
+		return
+	}
+
+	var req apiNewMessage
+	if err := readJSON(w, r, &req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	content, err := s.checkContent(req.Content)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	msg, err := s.postMessage(nil, bot, room, content, req.ReplyTo)
+	if err != nil {
+		status := http.StatusUnprocessableEntity
+		if errors.Is(err, ErrMessageNotFound) {
+			status = http.StatusNotFound
+		}
+		writeAPIError(w, status, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, msg)
+}
+
+func newAPIRoom(room *Room) apiRoom {
+	return apiRoom{
+		ID:      room.ID,
+		Name:    room.Name,
+		Owner:   room.Owner,
+		Clients: len(room.Clients.List()),
+	}
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v any) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBodySize))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, apiError{Error: err.Error()})
+}
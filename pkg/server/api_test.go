@@ -0,0 +1,64 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jnaraujo/letschat/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func apiRequest(t *testing.T, s *Server, method, path, token, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAPIAuth(t *testing.T) {
+	s := NewServer(WithBot("ci", "token"))
+
+	for _, token := range []string{"", "wrong"} {
+		rec := apiRequest(t, s, http.MethodGet, "/api/rooms", token, "")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	}
+
+	rec := apiRequest(t, s, http.MethodGet, "/api/rooms", "token", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestAPIPostMessage(t *testing.T) {
+	s := NewServer(WithBot("ci", "token"))
+
+	rec := apiRequest(t, s, http.MethodPost, "/api/rooms", "token", `{"name":"builds"}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var room apiRoom
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &room))
+	assert.Equal(t, "builds", room.Name)
+
+	rec = apiRequest(t, s, http.MethodPost, "/api/rooms/"+string(room.ID)+"/messages", "token",
+		`{"content":"build \u001b[31mpassed"}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var msg protocol.ChatMessage
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &msg))
+	assert.Equal(t, "build passed", msg.Content)
+	assert.Equal(t, "ci", msg.Author.Username)
+	assert.True(t, msg.Author.Bot)
+
+	stored, ok := s.rooms.Find(room.ID).History.Find(msg.ID)
+	assert.True(t, ok)
+	assert.Equal(t, msg.Content, stored.Content)
+
+	rec = apiRequest(t, s, http.MethodPost, "/api/rooms/missing/messages", "token", `{"content":"hi"}`)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = apiRequest(t, s, http.MethodPost, "/api/rooms/"+string(room.ID)+"/messages", "token", `{"content":""}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"github.com/jnaraujo/letschat/pkg/audit"
+)
+
+// WithAuditLog records security-relevant events, like authentications,
+// moderation and admin commands, in the audit log.
+func WithAuditLog(l *audit.Logger) Option {
+	return func(s *Server) {
+		s.auditLog = l
+	}
+}
+
+// WithMessageContentLogging makes the server log the content of every message
+// it receives. It is disabled by default, for privacy.
+func WithMessageContentLogging(enabled bool) Option {
+	return func(s *Server) {
+		s.logMessageContent = enabled
+	}
+}
+
+// audit records an event done by the client in the room. Both can be nil.
+func (s *Server) audit(eventType audit.EventType, client *Client, room *Room, target, detail string) {
+	var ev audit.Event
+	if client != nil {
+		ev = audit.NewEvent(eventType, client.Account())
+		ev.IP = client.Conn.RemoteAddr()
+	} else {
+		ev = audit.NewEvent(eventType, nil)
+	}
+	if room != nil {
+		ev.RoomID = room.ID
+	}
+	ev.Target = target
+	ev.Detail = detail
+	s.auditLog.Log(ev)
+}
@@ -1,26 +1,92 @@
 package server
 
 import (
+	"context"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jnaraujo/letschat/pkg/account"
 	"github.com/jnaraujo/letschat/pkg/id"
+	"github.com/jnaraujo/letschat/pkg/protocol"
 )
 
 type Client struct {
 	Conn     Connection
-	Account  *account.Account
-	RoomID   id.ID
 	JoinedAt time.Time
+	Latency  *protocol.LatencyTracker
+	Presence *Presence
+
+	// account is never modified, only replaced, because messages keep a
+	// pointer to the account of their author.
+	account atomic.Pointer[account.Account]
+	admin   atomic.Bool
+	// roomID is changed by whoever moves the client, like an admin deleting
+	// its room, while the goroutine of the client reads it.
+	roomID atomic.Pointer[id.ID]
+
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
-func NewClient(account *account.Account, conn Connection) *Client {
-	return &Client{
-		Account:  account,
+// NewClient returns a client whose context is cancelled when it disconnects or
+// when ctx is cancelled.
+func NewClient(ctx context.Context, account *account.Account, conn Connection) *Client {
+	client := &Client{
 		JoinedAt: time.Now(),
 		Conn:     conn,
+		Latency:  protocol.NewLatencyTracker(),
+		Presence: NewPresence(),
 	}
+	client.ctx, client.cancel = context.WithCancel(ctx)
+	client.account.Store(account)
+	return client
+}
+
+// Context is cancelled when the client disconnects. The goroutines serving the
+// client stop when it is done.
+func (c *Client) Context() context.Context {
+	return c.ctx
+}
+
+// Disconnect cancels the context of the client and closes its connection. The
+// packets already sent to the client are still written.
+func (c *Client) Disconnect() {
+	c.cancel()
+	c.Conn.Close()
+}
+
+func (c *Client) Account() *account.Account {
+	return c.account.Load()
+}
+
+// RoomID returns the ID of the room the client is in.
+func (c *Client) RoomID() id.ID {
+	if roomID := c.roomID.Load(); roomID != nil {
+		return *roomID
+	}
+	return ""
+}
+
+func (c *Client) SetRoomID(roomID id.ID) {
+	c.roomID.Store(&roomID)
+}
+
+// IsAdmin reports whether the client can run the admin commands.
+func (c *Client) IsAdmin() bool {
+	return c.admin.Load()
+}
+
+func (c *Client) SetAdmin(admin bool) {
+	c.admin.Store(admin)
+}
+
+// SetUsername changes the username of the client. Messages it sent before keep
+// the old username.
+func (c *Client) SetUsername(username string) {
+	acc := *c.Account()
+	acc.Username = username
+	c.account.Store(&acc)
 }
 
 type ClientList struct {
@@ -37,7 +103,7 @@ func NewClientList() *ClientList {
 func (cl *ClientList) Add(client *Client) {
 	cl.mutex.Lock()
 	defer cl.mutex.Unlock()
-	cl.clients[client.Account.ID] = client
+	cl.clients[client.Account().ID] = client
 }
 
 func (cl *ClientList) Find(id id.ID) *Client {
@@ -3,11 +3,14 @@ package server
 import (
 	"fmt"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/jnaraujo/letschat/pkg/audit"
 	"github.com/jnaraujo/letschat/pkg/id"
 	"github.com/jnaraujo/letschat/pkg/protocol"
+	"github.com/jnaraujo/letschat/pkg/sanitize"
 	"github.com/jnaraujo/letschat/pkg/utils"
 )
 
@@ -17,10 +20,35 @@ type CommandProps struct {
 	Server        *Server
 }
 
+type CommandHandler func(props *CommandProps)
+
+// commands maps the name of each command, the first word of the message, to its
+// handler.
+var commands = map[string]CommandHandler{
+	"ls":       lsCommand,
+	"ping":     pingCommand,
+	"join":     joinRoomCommand,
+	"new":      createRoomCommand,
+	"history":  historyCommand,
+	"thread":   threadCommand,
+	"status":   statusCommand,
+	"unread":   unreadCommand,
+	"mentions": mentionsCommand,
+	"nick":     nickCommand,
+	"filter":   filterCommand,
+	"webhook":  webhookCommand,
+	"admin":    adminCommand,
+}
+
+const (
+	defaultHistoryCount = 20
+	maxHistoryCount     = 100
+)
+
 func lsCommand(props *CommandProps) {
 	var res strings.Builder
 
-	room := props.Server.rooms.Find(props.MessageAuthor.RoomID)
+	room := props.Server.rooms.Find(props.MessageAuthor.RoomID())
 	if room == nil {
 		props.MessageAuthor.Conn.WritePacket(
 			protocol.NewCommandChatMessage(
@@ -38,10 +66,12 @@ func lsCommand(props *CommandProps) {
 			continue
 		}
 
-		res.WriteString(fmt.Sprintf(" %s (%s) - %s\n",
-			client.Account.Username,
-			string(client.Account.ID),
+		res.WriteString(fmt.Sprintf(" %s (%s) - %s - %s - %s\n",
+			client.Account().Username,
+			string(client.Account().ID),
 			utils.FormatDuration(time.Since(client.JoinedAt)),
+			utils.FormatLatency(client.Latency.RTT()),
+			formatStatus(client.Presence.Status()),
 		))
 	}
 	res.WriteString("================================")
@@ -56,10 +86,15 @@ func createRoomCommand(props *CommandProps) {
 	if len(words) != 2 {
 		return
 	}
-	name := words[1]
+	name, err := validateRoomName(words[1])
+	if err != nil {
+		sendCommandError(props.MessageAuthor, "Failed to create room", err)
+		return
+	}
 
-	room := NewRoom(name, props.Msg.Author)
+	room := NewRoom(name, props.MessageAuthor.Account())
 	props.Server.rooms.Add(room)
+	props.Server.audit(audit.RoomCreate, props.MessageAuthor, room, room.Name, "")
 
 	props.MessageAuthor.Conn.WritePacket(
 		protocol.NewCommandChatMessage(
@@ -81,17 +116,355 @@ func joinRoomCommand(props *CommandProps) {
 		return
 	}
 
-	props.Server.addClientToRoom(props.MessageAuthor, id.ID(roomID))
+	err := props.Server.addClientToRoom(props.MessageAuthor, id.ID(roomID))
+	if err != nil {
+		sendCommandError(props.MessageAuthor, "Failed to join room", err)
+	}
+}
+
+func nickCommand(props *CommandProps) {
+	words := strings.Split(props.Msg.Content, " ")
+	if len(words) != 2 {
+		props.MessageAuthor.Conn.WritePacket(
+			protocol.NewCommandChatMessage("Usage: /nick <username>", time.Now()).ToPacket(),
+		)
+		return
+	}
+	username, err := validateUsername(words[1])
+	if err != nil {
+		sendCommandError(props.MessageAuthor, "Failed to change username", err)
+		return
+	}
+
+	s := props.Server
+	client := props.MessageAuthor
+
+	s.namesMutex.Lock()
+	err = s.checkUsernameAvailable(username, client.RoomID(), client.Account().ID)
+	if err != nil {
+		s.namesMutex.Unlock()
+		sendCommandError(client, "Failed to change username", err)
+		return
+	}
+	oldUsername := client.Account().Username
+	client.SetUsername(username)
+	s.namesMutex.Unlock()
+	s.audit(audit.AccountRename, client, nil, username, "was "+oldUsername)
+
+	room := s.rooms.Find(client.RoomID())
+	if room == nil {
+		return
+	}
+	room.Broadcast(protocol.NewServerChatMessage(
+		fmt.Sprintf("%s (%s) is now known as %s", oldUsername, client.Account().ID, username),
+		room.ChatRoom(),
+		time.Now(),
+	))
 }
 
 func pingCommand(props *CommandProps) {
 	props.MessageAuthor.Conn.WritePacket(
 		protocol.NewCommandChatMessage(
-			// in ping commands, the createdAt remains the same as the original sent
-			// maybe fix this in the future idk
-			"Pong!", props.Msg.CreatedAt,
+			fmt.Sprintf("Pong! Latency measured by the server: %s",
+				utils.FormatLatency(props.MessageAuthor.Latency.RTT())),
+			time.Now(),
+		).ToPacket(),
+	)
+}
+
+func historyCommand(props *CommandProps) {
+	room := props.Server.rooms.Find(props.MessageAuthor.RoomID())
+	if room == nil {
+		props.MessageAuthor.Conn.WritePacket(
+			protocol.NewCommandChatMessage(
+				"You need to be connected to a room to view its history.",
+				time.Now(),
+			).ToPacket(),
+		)
+		return
+	}
+
+	count := defaultHistoryCount
+	words := strings.Split(props.Msg.Content, " ")
+	if len(words) == 2 {
+		n, err := strconv.Atoi(words[1])
+		if err != nil || n <= 0 {
+			props.MessageAuthor.Conn.WritePacket(
+				protocol.NewCommandChatMessage("Usage: /history [count]", time.Now()).ToPacket(),
+			)
+			return
+		}
+		count = min(n, maxHistoryCount)
+	}
+
+	for _, msg := range room.History.List(count) {
+		props.MessageAuthor.Conn.WritePacket(msg.ToPacket())
+	}
+}
+
+func threadCommand(props *CommandProps) {
+	words := strings.Split(props.Msg.Content, " ")
+	if len(words) != 2 {
+		props.MessageAuthor.Conn.WritePacket(
+			protocol.NewCommandChatMessage("Usage: /thread <message id>", time.Now()).ToPacket(),
+		)
+		return
+	}
+
+	room := props.Server.rooms.Find(props.MessageAuthor.RoomID())
+	if room == nil {
+		return
+	}
+
+	parent, ok := room.History.Find(id.ID(words[1]))
+	if !ok {
+		sendCommandError(props.MessageAuthor, "Failed to show thread", ErrMessageNotFound)
+		return
+	}
+	replies := room.History.Replies(parent.ID)
+
+	props.MessageAuthor.Conn.WritePacket(
+		protocol.NewCommandChatMessage(
+			fmt.Sprintf("==== Thread with %d answer%s ====", len(replies), utils.Plural(len(replies))),
+			time.Now(),
+		).ToPacket(),
+	)
+	props.MessageAuthor.Conn.WritePacket(parent.ToPacket())
+	for _, reply := range replies {
+		// the parent was just shown, so there is no need to quote it again
+		reply.Quote = nil
+		props.MessageAuthor.Conn.WritePacket(reply.ToPacket())
+	}
+}
+
+func statusCommand(props *CommandProps) {
+	words := strings.SplitN(props.Msg.Content, " ", 3)
+	if len(words) == 1 {
+		props.MessageAuthor.Conn.WritePacket(
+			protocol.NewCommandChatMessage(
+				"Your status is "+formatStatus(props.MessageAuthor.Presence.Status()),
+				time.Now(),
+			).ToPacket(),
+		)
+		return
+	}
+
+	status := protocol.Status(words[1])
+	if !status.IsValid() {
+		props.MessageAuthor.Conn.WritePacket(
+			protocol.NewCommandChatMessage(
+				"Usage: /status <online|away|dnd> [custom text]", time.Now(),
+			).ToPacket(),
+		)
+		return
+	}
+
+	var text string
+	if len(words) == 3 {
+		var err error
+		text, err = sanitize.Line(words[2])
+		if err != nil {
+			sendCommandError(props.MessageAuthor, "Failed to set status", err)
+			return
+		}
+		text = strings.TrimSpace(text)
+	}
+	if sanitize.Length(text) > MaxStatusText {
+		props.MessageAuthor.Conn.WritePacket(
+			protocol.NewCommandChatMessage(
+				fmt.Sprintf("The status text can have at most %d characters.", MaxStatusText),
+				time.Now(),
+			).ToPacket(),
+		)
+		return
+	}
+
+	props.MessageAuthor.Presence.Set(status, text)
+	// setting a status counts as activity, otherwise "online" could be
+	// immediately shown as away
+	props.MessageAuthor.Presence.Touch()
+
+	props.MessageAuthor.Conn.WritePacket(
+		protocol.NewCommandChatMessage(
+			"Your status is now "+formatStatus(status, text), time.Now(),
+		).ToPacket(),
+	)
+}
+
+func unreadCommand(props *CommandProps) {
+	accountID := props.MessageAuthor.Account().ID
+	markers := props.Server.readMarkers.Rooms(accountID)
+
+	// the current room is always listed, even if nothing was read there yet
+	if _, ok := markers[props.MessageAuthor.RoomID()]; !ok {
+		markers[props.MessageAuthor.RoomID()] = ReadMarker{
+			CreatedAt: props.MessageAuthor.JoinedAt,
+		}
+	}
+
+	var res strings.Builder
+	res.WriteString("==== Unread Messages ====\n")
+	total := 0
+	for _, room := range props.Server.rooms.List() {
+		marker, ok := markers[room.ID]
+		if !ok {
+			continue
+		}
+
+		count := room.History.CountUnread(marker.CreatedAt, accountID)
+		if count == 0 {
+			continue
+		}
+		total += count
+		res.WriteString(fmt.Sprintf(" %s (%s) - %d unread message%s\n",
+			room.Name, room.ID, count, utils.Plural(count)))
+	}
+	if total == 0 {
+		res.WriteString(" No unread messages\n")
+	}
+	res.WriteString("=========================")
+
+	props.MessageAuthor.Conn.WritePacket(
+		protocol.NewCommandChatMessage(res.String(), time.Now()).ToPacket(),
+	)
+}
+
+func mentionsCommand(props *CommandProps) {
+	mentions := props.Server.mentions.List(props.MessageAuthor.Account().ID)
+	if len(mentions) == 0 {
+		props.MessageAuthor.Conn.WritePacket(
+			protocol.NewCommandChatMessage("Nobody mentioned you yet.", time.Now()).ToPacket(),
+		)
+		return
+	}
+
+	props.MessageAuthor.Conn.WritePacket(
+		protocol.NewCommandChatMessage(
+			fmt.Sprintf("==== %d Recent Mention%s ====", len(mentions), utils.Plural(len(mentions))),
+			time.Now(),
 		).ToPacket(),
 	)
+	for _, msg := range mentions {
+		props.MessageAuthor.Conn.WritePacket(msg.ToPacket())
+	}
+}
+
+func filterCommand(props *CommandProps) {
+	client := props.MessageAuthor
+	wf := props.Server.wordFilter
+	if wf == nil {
+		client.Conn.WritePacket(
+			protocol.NewCommandChatMessage("The word filter is disabled on this server.", time.Now()).ToPacket(),
+		)
+		return
+	}
+
+	room := props.Server.rooms.Find(client.RoomID())
+	if room == nil {
+		return
+	}
+
+	words := strings.Fields(props.Msg.Content)
+	if len(words) == 1 || (len(words) == 2 && words[1] == "list") {
+		blocked := wf.RoomWords(room.ID)
+		content := "No words are blocked in this room."
+		if len(blocked) > 0 {
+			content = "Words blocked in this room: " + strings.Join(blocked, ", ")
+		}
+		client.Conn.WritePacket(protocol.NewCommandChatMessage(content, time.Now()).ToPacket())
+		return
+	}
+	if len(words) != 3 || (words[1] != "add" && words[1] != "remove") {
+		client.Conn.WritePacket(
+			protocol.NewCommandChatMessage("Usage: /filter [list | add <word> | remove <word>]", time.Now()).ToPacket(),
+		)
+		return
+	}
+	if !room.IsModerator(client.Account().ID) {
+		sendCommandError(client, "Failed to change the word filter", ErrNotModerator)
+		return
+	}
+
+	var content string
+	if words[1] == "add" {
+		content = fmt.Sprintf("%q is already blocked in this room.", words[2])
+		if wf.AddRoomWord(room.ID, words[2]) {
+			content = fmt.Sprintf("%q is now blocked in this room.", words[2])
+			props.Server.audit(audit.FilterChange, client, room, words[2], "add")
+		}
+	} else {
+		content = fmt.Sprintf("%q is not blocked in this room.", words[2])
+		if wf.RemoveRoomWord(room.ID, words[2]) {
+			content = fmt.Sprintf("%q is no longer blocked in this room.", words[2])
+			props.Server.audit(audit.FilterChange, client, room, words[2], "remove")
+		}
+	}
+	client.Conn.WritePacket(protocol.NewCommandChatMessage(content, time.Now()).ToPacket())
+}
+
+func webhookCommand(props *CommandProps) {
+	client := props.MessageAuthor
+	if props.Server.webhooks == nil {
+		sendCommandError(client, "Failed to manage webhooks", ErrWebhooksDisabled)
+		return
+	}
+
+	room := props.Server.rooms.Find(client.RoomID())
+	if room == nil {
+		return
+	}
+	if !room.IsModerator(client.Account().ID) {
+		sendCommandError(client, "Failed to manage webhooks", ErrNotModerator)
+		return
+	}
+
+	words := strings.Fields(props.Msg.Content)
+	switch {
+	case len(words) == 1 || (len(words) == 2 && words[1] == "list"):
+		hooks := room.Webhooks.List()
+		var res strings.Builder
+		fmt.Fprintf(&res, "==== %d Webhook%s ====", len(hooks), utils.Plural(len(hooks)))
+		for _, hook := range hooks {
+			fmt.Fprintf(&res, "\n%s %s", hook.ID, hook.URL)
+		}
+		client.Conn.WritePacket(protocol.NewCommandChatMessage(res.String(), time.Now()).ToPacket())
+	case len(words) == 3 && words[1] == "add":
+		hook, err := props.Server.webhooks.NewWebhook(props.Server.ctx, words[2])
+		if err != nil {
+			sendCommandError(client, "Failed to add webhook", err)
+			return
+		}
+		if err := room.Webhooks.Add(hook); err != nil {
+			hook.Close()
+			sendCommandError(client, "Failed to add webhook", err)
+			return
+		}
+		props.Server.audit(audit.WebhookChange, client, room, string(hook.ID), "add "+hook.URL)
+		client.Conn.WritePacket(
+			protocol.NewCommandChatMessage(
+				fmt.Sprintf("Webhook %s added. Verify its requests with the secret %s", hook.ID, hook.Secret),
+				time.Now(),
+			).ToPacket(),
+		)
+	case len(words) == 3 && words[1] == "remove":
+		content := fmt.Sprintf("Webhook %s not found.", words[2])
+		if room.Webhooks.Remove(id.ID(words[2])) {
+			content = fmt.Sprintf("Webhook %s removed.", words[2])
+			props.Server.audit(audit.WebhookChange, client, room, words[2], "remove")
+		}
+		client.Conn.WritePacket(protocol.NewCommandChatMessage(content, time.Now()).ToPacket())
+	default:
+		client.Conn.WritePacket(
+			protocol.NewCommandChatMessage("Usage: /webhook [list | add <url> | remove <id>]", time.Now()).ToPacket(),
+		)
+	}
+}
+
+func formatStatus(status protocol.Status, text string) string {
+	if text == "" {
+		return string(status)
+	}
+	return fmt.Sprintf("%s (%s)", status, text)
 }
 
 func sortClientIDsByJoinTime(clients []*Client) []id.ID {
@@ -101,7 +474,7 @@ func sortClientIDsByJoinTime(clients []*Client) []id.ID {
 
 	clientIDs := make([]id.ID, 0, len(clients))
 	for _, client := range clients {
-		clientIDs = append(clientIDs, client.Account.ID)
+		clientIDs = append(clientIDs, client.Account().ID)
 	}
 
 	return clientIDs
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRoom creates a room with the /new command and returns it.
+func newTestRoom(t *testing.T, h *testHarness, owner *testClient, name string) *Room {
+	t.Helper()
+
+	owner.SendCommand("new " + name)
+	res := owner.ExpectCommandResponse()
+	for _, room := range h.server.rooms.List() {
+		if room.Name == name {
+			assert.Contains(t, res.Content, "/join "+string(room.ID))
+			return room
+		}
+	}
+	require.FailNow(t, "room was not created", res.Content)
+	return nil
+}
+
+func TestRooms(t *testing.T) {
+	h := newTestHarness(t)
+	clients := h.ConnectAll("alice", "bobby", "carol")
+	alice, bobby, carol := clients[0], clients[1], clients[2]
+
+	room := newTestRoom(t, h, alice, "games")
+	assert.Equal(t, alice.Account.ID, room.Owner.ID)
+
+	bobby.SendCommand("join " + string(room.ID))
+	assert.Contains(t, bobby.ExpectServerMessage().Content, "bobby ("+string(bobby.Account.ID)+") joined")
+	for _, client := range []*testClient{alice, carol} {
+		assert.Contains(t, client.ExpectServerMessage().Content, "left the chat")
+	}
+
+	// messages only reach the clients of the room
+	bobby.SendMessage("anyone here?")
+	msg := bobby.ExpectChatMessage()
+	assert.Equal(t, room.ID, msg.Room.ID)
+	alice.ExpectNothing()
+	carol.ExpectNothing()
+
+	alice.SendMessage("hi")
+	assert.Equal(t, "hi", carol.ExpectChatMessage().Content)
+	assert.Equal(t, "hi", alice.ExpectChatMessage().Content)
+	bobby.ExpectNothing()
+
+	// clients can connect straight to a room
+	dave := h.ConnectRoom("david", room.ID)
+	assert.Contains(t, bobby.ExpectServerMessage().Content, "david")
+	dave.SendMessage("hello")
+	assert.Equal(t, "hello", bobby.ExpectChatMessage().Content)
+	assert.Equal(t, "hello", dave.ExpectChatMessage().Content)
+}
+
+func TestRoomNames(t *testing.T) {
+	h := newTestHarness(t)
+	alice := h.Connect("alice")
+
+	// names are unique per room by default
+	room := newTestRoom(t, h, alice, "games")
+	h.ConnectRoom("bobby", room.ID)
+	bobby := h.Connect("bobby")
+
+	bobby.SendCommand("join " + string(room.ID))
+	assert.Contains(t, bobby.ExpectCommandResponse().Content, ErrUsernameTaken.Error())
+	assert.Equal(t, defaultRoomID, h.server.findClient(bobby.Account.ID).RoomID())
+}
+
+func TestCommands(t *testing.T) {
+	h := newTestHarness(t)
+	clients := h.ConnectAll("alice", "bobby")
+	alice, bobby := clients[0], clients[1]
+
+	t.Run("unknown", func(t *testing.T) {
+		alice.SendCommand("nope")
+		assert.Equal(t, "command not found", alice.ExpectCommandResponse().Content)
+		bobby.ExpectNothing()
+	})
+
+	t.Run("ls", func(t *testing.T) {
+		alice.SendCommand("ls")
+		res := alice.ExpectCommandResponse().Content
+		assert.Contains(t, res, "alice ("+string(alice.Account.ID)+")")
+		assert.Contains(t, res, "bobby ("+string(bobby.Account.ID)+")")
+	})
+
+	t.Run("nick", func(t *testing.T) {
+		alice.SendCommand("nick alicia")
+		for _, client := range clients {
+			assert.Contains(t, client.ExpectServerMessage().Content, "is now known as alicia")
+		}
+		alice.SendMessage("renamed")
+		assert.Equal(t, "alicia", bobby.ExpectChatMessage().Author.Username)
+		alice.ExpectChatMessage()
+
+		alice.SendCommand("nick bobby")
+		assert.Contains(t, alice.ExpectCommandResponse().Content, ErrUsernameTaken.Error())
+		alice.SendCommand("nick ab")
+		assert.Contains(t, alice.ExpectCommandResponse().Content, ErrUsernameTooShort.Error())
+		bobby.ExpectNothing()
+	})
+
+	t.Run("admin only", func(t *testing.T) {
+		alice.SendCommand("admin rooms")
+		assert.Contains(t, alice.ExpectCommandResponse().Content, ErrNotAdmin.Error())
+	})
+}
@@ -0,0 +1,360 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/jnaraujo/letschat/pkg/account"
+	"github.com/jnaraujo/letschat/pkg/id"
+	"github.com/jnaraujo/letschat/pkg/protocol"
+	"github.com/jnaraujo/letschat/pkg/sanitize"
+	"github.com/jnaraujo/letschat/pkg/secure"
+)
+
+const (
+	DefaultMaxFileSize   = 10 << 20 // 10 MiB
+	DefaultAddressQuota  = 50 << 20 // 50 MiB
+	DefaultMaxTotalSize  = 1 << 30  // 1 GiB
+	DefaultFileTTL       = 24 * time.Hour
+	DefaultSweepInterval = time.Minute
+	// UploadTTL is how long an accepted offer waits for its upload.
+	UploadTTL         = 10 * time.Minute
+	maxFileNameLength = 255
+	fileIDLength      = 22
+)
+
+var (
+	ErrFileTooLarge    = errors.New("file is too large")
+	ErrQuotaExceeded   = errors.New("file quota exceeded")
+	ErrStorageFull     = errors.New("the server cannot store more files right now")
+	ErrInvalidFileName = errors.New("invalid file name")
+	ErrUploadNotFound  = errors.New("upload not found or expired")
+	ErrFileNotFound    = errors.New("file not found")
+	ErrInvalidToken    = errors.New("invalid token")
+	ErrSizeMismatch    = errors.New("file size does not match the offer")
+)
+
+type PendingUpload struct {
+	ID      id.ID
+	Token   string
+	Offer   protocol.FileOffer
+	Account *account.Account
+	// Owner is the IP address the offer came from, whose quota it counts in.
+	Owner  string
+	RoomID id.ID
+	// Content is the content of the message posted with the file.
+	Content   string
+	ExpiresAt time.Time
+}
+
+type storedFile struct {
+	attachment protocol.FileAttachment
+	owner      string
+	expiresAt  time.Time
+}
+
+// FileStore keeps the files sent to rooms on disk until they expire. Each IP
+// address can only store up to its quota, and every address together up to
+// MaxTotalSize, counting the uploads they were allowed to start. Account IDs
+// are new on every connection, so they cannot be used for the quota.
+type FileStore struct {
+	// MaxTotalSize is the most bytes stored at once.
+	MaxTotalSize int64
+	// FileTTL is how long files are kept after being uploaded.
+	FileTTL time.Duration
+	// SweepInterval is how often Run removes the expired files.
+	SweepInterval time.Duration
+
+	dir          string
+	maxFileSize  int64
+	addressQuota int64
+
+	uploads map[id.ID]*PendingUpload
+	files   map[id.ID]storedFile
+	usage   map[string]int64
+	total   int64
+	mutex   sync.Mutex
+}
+
+// NewFileStore keeps the files in dir. Files left there by a previous run are
+// removed, since they cannot be downloaded anymore.
+func NewFileStore(dir string, maxFileSize, addressQuota int64) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	if err := removeStoredFiles(dir); err != nil {
+		return nil, err
+	}
+	return &FileStore{
+		MaxTotalSize:  DefaultMaxTotalSize,
+		FileTTL:       DefaultFileTTL,
+		SweepInterval: DefaultSweepInterval,
+		dir:           dir,
+		maxFileSize:   maxFileSize,
+		addressQuota:  addressQuota,
+		uploads:       make(map[id.ID]*PendingUpload),
+		files:         make(map[id.ID]storedFile),
+		usage:         make(map[string]int64),
+	}, nil
+}
+
+// removeStoredFiles removes the files of the store in dir. Other files are
+// left alone, in case dir is shared.
+func removeStoredFiles(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !isFileID(entry.Name()) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isFileID(name string) bool {
+	if len(name) != fileIDLength {
+		return false
+	}
+	for _, r := range name {
+		if !('0' <= r && r <= '9' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckOffer validates a file offer. It returns the offer with its name cleaned
+// and, when it is not valid, without its MIME type, which is then detected from
+// the content.
+func (fs *FileStore) CheckOffer(offer protocol.FileOffer) (protocol.FileOffer, error) {
+	name, err := sanitize.Line(filepath.Base(offer.Name))
+	if err != nil || name == "" || name == "." || name == string(filepath.Separator) || len(name) > maxFileNameLength {
+		return offer, ErrInvalidFileName
+	}
+	offer.Name = name
+
+	if offer.Size <= 0 || offer.Size > fs.maxFileSize {
+		return offer, ErrFileTooLarge
+	}
+	if _, _, err := mime.ParseMediaType(offer.MIMEType); err != nil {
+		offer.MIMEType = ""
+	}
+	return offer, nil
+}
+
+// Offer validates a file offer from the owner, an IP address, and reserves
+// space for it. Content is the content of the message posted in the room with
+// the file once it is uploaded.
+func (fs *FileStore) Offer(author *account.Account, owner string, roomID id.ID,
+	offer protocol.FileOffer, content string) (*PendingUpload, error) {
+	offer, err := fs.CheckOffer(offer)
+	if err != nil {
+		return nil, err
+	}
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	fs.removeExpired()
+
+	if fs.usage[owner]+offer.Size > fs.addressQuota {
+		return nil, ErrQuotaExceeded
+	}
+	if fs.total+offer.Size > fs.MaxTotalSize {
+		return nil, ErrStorageFull
+	}
+	fs.usage[owner] += offer.Size
+	fs.total += offer.Size
+
+	upload := &PendingUpload{
+		ID:        id.NewID(22),
+		Token:     secure.GenerateRandomString(32),
+		Offer:     offer,
+		Account:   author,
+		Owner:     owner,
+		RoomID:    roomID,
+		Content:   content,
+		ExpiresAt: time.Now().Add(UploadTTL),
+	}
+	fs.uploads[upload.ID] = upload
+	return upload, nil
+}
+
+// Upload stores the content of an accepted offer. The upload can only be
+// attempted once.
+func (fs *FileStore) Upload(uploadID id.ID, token string, r io.Reader) (*PendingUpload, protocol.FileAttachment, error) {
+	fs.mutex.Lock()
+	upload, ok := fs.uploads[uploadID]
+	if !ok {
+		fs.mutex.Unlock()
+		return nil, protocol.FileAttachment{}, ErrUploadNotFound
+	}
+	if !tokenEquals(upload.Token, token) {
+		fs.mutex.Unlock()
+		return nil, protocol.FileAttachment{}, ErrInvalidToken
+	}
+	delete(fs.uploads, uploadID)
+	fs.mutex.Unlock()
+
+	if time.Now().After(upload.ExpiresAt) {
+		fs.release(upload.Owner, upload.Offer.Size)
+		return nil, protocol.FileAttachment{}, ErrUploadNotFound
+	}
+
+	attachment, err := fs.write(upload, r)
+	if err != nil {
+		fs.release(upload.Owner, upload.Offer.Size)
+		return nil, attachment, err
+	}
+
+	fs.mutex.Lock()
+	fs.files[attachment.ID] = storedFile{
+		attachment: attachment,
+		owner:      upload.Owner,
+		expiresAt:  time.Now().Add(fs.FileTTL),
+	}
+	fs.mutex.Unlock()
+
+	return upload, attachment, nil
+}
+
+func (fs *FileStore) write(upload *PendingUpload, r io.Reader) (protocol.FileAttachment, error) {
+	attachment := protocol.FileAttachment{
+		ID:       id.NewID(fileIDLength),
+		Name:     upload.Offer.Name,
+		Size:     upload.Offer.Size,
+		MIMEType: upload.Offer.MIMEType,
+		Token:    secure.GenerateRandomString(32),
+	}
+	attachment.FetchPath = "/files/" + string(attachment.ID)
+
+	br := bufio.NewReader(r)
+	if attachment.MIMEType == "" {
+		head, _ := br.Peek(512)
+		attachment.MIMEType = http.DetectContentType(head)
+	}
+
+	path := fs.path(attachment.ID)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+	if err != nil {
+		return attachment, err
+	}
+
+	// one extra byte is read to find out if the client sent more than offered
+	n, err := io.Copy(f, io.LimitReader(br, upload.Offer.Size+1))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && n != upload.Offer.Size {
+		err = ErrSizeMismatch
+	}
+	if err != nil {
+		os.Remove(path)
+		return attachment, err
+	}
+
+	return attachment, nil
+}
+
+// Open opens a stored file for reading. The caller must close it.
+func (fs *FileStore) Open(fileID id.ID, token string) (*os.File, protocol.FileAttachment, error) {
+	fs.mutex.Lock()
+	fs.removeExpired()
+	file, ok := fs.files[fileID]
+	fs.mutex.Unlock()
+
+	attachment := file.attachment
+	if !ok {
+		return nil, attachment, ErrFileNotFound
+	}
+	if !tokenEquals(attachment.Token, token) {
+		return nil, protocol.FileAttachment{}, ErrInvalidToken
+	}
+
+	f, err := os.Open(fs.path(fileID))
+	if err != nil {
+		return nil, attachment, fmt.Errorf("failed to open file: %w", err)
+	}
+	return f, attachment, nil
+}
+
+func (fs *FileStore) path(fileID id.ID) string {
+	return filepath.Join(fs.dir, string(fileID))
+}
+
+func (fs *FileStore) release(owner string, size int64) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	fs.releaseLocked(owner, size)
+}
+
+func (fs *FileStore) releaseLocked(owner string, size int64) {
+	fs.total -= size
+	fs.usage[owner] -= size
+	if fs.usage[owner] <= 0 {
+		delete(fs.usage, owner)
+	}
+}
+
+// removeExpired deletes the expired files and gives back the space reserved by
+// them and by expired uploads. It must be called with the mutex held.
+func (fs *FileStore) removeExpired() {
+	now := time.Now()
+	for uploadID, upload := range fs.uploads {
+		if now.Before(upload.ExpiresAt) {
+			continue
+		}
+		delete(fs.uploads, uploadID)
+		fs.releaseLocked(upload.Owner, upload.Offer.Size)
+	}
+	for fileID, file := range fs.files {
+		if now.Before(file.expiresAt) {
+			continue
+		}
+		// downloads already started keep their open file
+		if err := os.Remove(fs.path(fileID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Error("failed to remove expired file", "err", err)
+			continue
+		}
+		delete(fs.files, fileID)
+		fs.releaseLocked(file.owner, file.attachment.Size)
+	}
+}
+
+// Run removes the expired files every SweepInterval until the context is done,
+// so they do not stay on disk until the next offer or download.
+func (fs *FileStore) Run(ctx context.Context) {
+	ticker := time.NewTicker(fs.SweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			fs.mutex.Lock()
+			fs.removeExpired()
+			fs.mutex.Unlock()
+		}
+	}
+}
+
+func tokenEquals(expected, token string) bool {
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(token)) == 1
+}
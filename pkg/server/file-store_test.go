@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jnaraujo/letschat/pkg/account"
+	"github.com/jnaraujo/letschat/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testOffer(size int64) protocol.FileOffer {
+	return protocol.FileOffer{Name: "notes.txt", Size: size, MIMEType: "text/plain"}
+}
+
+func TestFileStoreAddressQuota(t *testing.T) {
+	fs, err := NewFileStore(t.TempDir(), 100, 150)
+	require.NoError(t, err)
+
+	// reconnecting gives a new account, but the same address
+	_, err = fs.Offer(account.NewAccount("alice"), "10.0.0.1", "room", testOffer(100), "sent a file")
+	require.NoError(t, err)
+	_, err = fs.Offer(account.NewAccount("alice"), "10.0.0.1", "room", testOffer(100), "sent a file")
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+
+	_, err = fs.Offer(account.NewAccount("bobby"), "10.0.0.2", "room", testOffer(100), "sent a file")
+	assert.NoError(t, err)
+}
+
+func TestFileStoreMaxTotalSize(t *testing.T) {
+	fs, err := NewFileStore(t.TempDir(), 100, 100)
+	require.NoError(t, err)
+	fs.MaxTotalSize = 150
+
+	_, err = fs.Offer(account.NewAccount("alice"), "10.0.0.1", "room", testOffer(100), "sent a file")
+	require.NoError(t, err)
+	_, err = fs.Offer(account.NewAccount("bobby"), "10.0.0.2", "room", testOffer(100), "sent a file")
+	assert.ErrorIs(t, err, ErrStorageFull)
+}
+
+func TestFileStoreExpiry(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := NewFileStore(dir, 100, 100)
+	require.NoError(t, err)
+	fs.FileTTL = 0
+
+	upload, err := fs.Offer(account.NewAccount("alice"), "10.0.0.1", "room", testOffer(5), "sent a file")
+	require.NoError(t, err)
+	_, attachment, err := fs.Upload(upload.ID, upload.Token, strings.NewReader("hello"))
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(dir, string(attachment.ID)))
+
+	time.Sleep(time.Millisecond)
+	_, _, err = fs.Open(attachment.ID, attachment.Token)
+	assert.ErrorIs(t, err, ErrFileNotFound)
+	assert.NoFileExists(t, filepath.Join(dir, string(attachment.ID)))
+
+	// the space of the expired file can be used again
+	_, err = fs.Offer(account.NewAccount("alice"), "10.0.0.1", "room", testOffer(100), "sent a file")
+	assert.NoError(t, err)
+}
+
+func TestFileStoreRun(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := NewFileStore(dir, 100, 100)
+	require.NoError(t, err)
+	fs.FileTTL = 0
+	fs.SweepInterval = time.Millisecond
+
+	upload, err := fs.Offer(account.NewAccount("alice"), "10.0.0.1", "room", testOffer(5), "sent a file")
+	require.NoError(t, err)
+	_, attachment, err := fs.Upload(upload.ID, upload.Token, strings.NewReader("hello"))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		fs.Run(ctx)
+		close(done)
+	}()
+
+	// expired files are removed without waiting for an offer or download
+	path := filepath.Join(dir, string(attachment.ID))
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(path)
+		return os.IsNotExist(err)
+	}, 2*time.Second, 5*time.Millisecond)
+	fs.mutex.Lock()
+	assert.Zero(t, fs.total)
+	assert.Empty(t, fs.usage)
+	fs.mutex.Unlock()
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not return after the context was done")
+	}
+}
+
+func TestNewFileStoreRemovesOldFiles(t *testing.T) {
+	dir := t.TempDir()
+	old := filepath.Join(dir, "abcdefghijkmnopqrstuvw")
+	other := filepath.Join(dir, "README")
+	require.NoError(t, os.WriteFile(old, []byte("old"), 0o600))
+	require.NoError(t, os.WriteFile(other, []byte("keep"), 0o600))
+
+	_, err := NewFileStore(dir, 100, 100)
+	require.NoError(t, err)
+	assert.NoFileExists(t, old)
+	assert.FileExists(t, other)
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jnaraujo/letschat/pkg/account"
+	"github.com/jnaraujo/letschat/pkg/id"
+	"github.com/jnaraujo/letschat/pkg/protocol"
+)
+
+var ErrFilesDisabled = errors.New("file transfer is disabled on this server")
+
+func (s *Server) handleFileOffer(client *Client, pkt *protocol.Packet) {
+	offer, err := protocol.FileOfferFromPacket(pkt)
+	if err != nil {
+		slog.Error("error reading file offer", "err", err)
+		return
+	}
+
+	res := protocol.FileOfferResponse{
+		Ref:    offer.Ref,
+		Status: "error",
+	}
+
+	if s.files == nil {
+		res.Content = ErrFilesDisabled.Error()
+		client.Conn.WritePacket(res.ToPacket())
+		return
+	}
+
+	room := s.rooms.Find(client.RoomID())
+	if room == nil {
+		res.Content = "you need to be connected to a room to send files"
+		client.Conn.WritePacket(res.ToPacket())
+		return
+	}
+
+	offer, err = s.files.CheckOffer(offer)
+	if err != nil {
+		res.Content = err.Error()
+		client.Conn.WritePacket(res.ToPacket())
+		return
+	}
+
+	// the message posted with the file goes through the filters and hooks
+	// before the file is uploaded, so it can be rejected right away
+	msg := newFileMessage(client.Account(), room, protocol.FileAttachment{
+		Name:     offer.Name,
+		Size:     offer.Size,
+		MIMEType: offer.MIMEType,
+	})
+	if err := s.screenMessage(client, room, &msg, false); err != nil {
+		res.Content = err.Error()
+		client.Conn.WritePacket(res.ToPacket())
+		return
+	}
+
+	upload, err := s.files.Offer(client.Account(), client.Conn.RemoteAddr(), room.ID, offer, msg.Content)
+	if err != nil {
+		res.Content = err.Error()
+		client.Conn.WritePacket(res.ToPacket())
+		return
+	}
+
+	res.Status = "ok"
+	res.UploadPath = "/files/" + string(upload.ID)
+	res.UploadToken = upload.Token
+	client.Conn.WritePacket(res.ToPacket())
+}
+
+func newFileMessage(author *account.Account, room *Room, attachment protocol.FileAttachment) protocol.ChatMessage {
+	msg := protocol.NewChatMessage(author, "sent a file", room.ChatRoom(), time.Now())
+	msg.Attachment = &attachment
+	return msg
+}
+
+func (s *Server) handleFileUpload(w http.ResponseWriter, r *http.Request) {
+	if s.files == nil {
+		http.Error(w, ErrFilesDisabled.Error(), http.StatusNotFound)
+		return
+	}
+
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	upload, attachment, err := s.files.Upload(id.ID(r.PathValue("id")), token, r.Body)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUploadNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, ErrInvalidToken):
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		case errors.Is(err, ErrSizeMismatch):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			slog.Error("failed to store file", "err", err)
+			http.Error(w, "failed to store file", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	slog.Info("file uploaded",
+		"from-addr", getRealIP(r),
+		"from", upload.Account.Username,
+		"name", attachment.Name,
+		"size", attachment.Size,
+	)
+
+	room := s.rooms.Find(upload.RoomID)
+	if room != nil {
+		msg := newFileMessage(upload.Account, room, attachment)
+		msg.Content = upload.Content
+		room.Post(msg)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(attachment)
+}
+
+func (s *Server) handleFileDownload(w http.ResponseWriter, r *http.Request) {
+	if s.files == nil {
+		http.Error(w, ErrFilesDisabled.Error(), http.StatusNotFound)
+		return
+	}
+
+	f, attachment, err := s.files.Open(id.ID(r.PathValue("id")), r.URL.Query().Get("token"))
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrFileNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, ErrInvalidToken):
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		default:
+			slog.Error("failed to open file", "err", err)
+			http.Error(w, "failed to open file", http.StatusInternalServerError)
+		}
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", attachment.MIMEType)
+	w.Header().Set("Content-Length", fmt.Sprint(attachment.Size))
+	w.Header().Set("Content-Disposition",
+		mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Name}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	io.Copy(w, f)
+}
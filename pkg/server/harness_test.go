@@ -0,0 +1,219 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jnaraujo/letschat/pkg/account"
+	"github.com/jnaraujo/letschat/pkg/id"
+	"github.com/jnaraujo/letschat/pkg/protocol"
+	"github.com/stretchr/testify/require"
+)
+
+// packetTimeout is how long a test client waits for a packet it expects.
+const packetTimeout = 2 * time.Second
+
+// testHarness runs a server whose clients are connected with in-memory pipes.
+type testHarness struct {
+	t      *testing.T
+	server *Server
+}
+
+func newTestHarness(t *testing.T, opts ...Option) *testHarness {
+	t.Helper()
+
+	s := NewServer(append([]Option{WithDrainDelay(0)}, opts...)...)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), packetTimeout)
+		defer cancel()
+		s.Shutdown(ctx)
+	})
+	return &testHarness{t: t, server: s}
+}
+
+// Dial connects a client without authenticating it.
+func (h *testHarness) Dial() *testClient {
+	h.t.Helper()
+
+	serverConn, clientConn := NewPipe()
+	go h.server.ServeConn(serverConn)
+
+	tc := &testClient{
+		t:       h.t,
+		conn:    clientConn,
+		packets: make(chan *protocol.Packet, 1024),
+	}
+	go tc.readPackets()
+	h.t.Cleanup(func() { tc.Close() })
+	return tc
+}
+
+// Connect connects and authenticates a client in the default room. The
+// packets sent while it joined, like its own join message, are consumed.
+func (h *testHarness) Connect(username string) *testClient {
+	h.t.Helper()
+	return h.ConnectRoom(username, "")
+}
+
+func (h *testHarness) ConnectRoom(username string, roomID id.ID) *testClient {
+	h.t.Helper()
+
+	tc := h.Dial()
+	res := tc.Auth(username, roomID)
+	require.Equal(h.t, "ok", res.Status, res.Content)
+	tc.Account = res.Account
+	tc.ExpectServerMessage()
+	return tc
+}
+
+// ConnectAll connects the clients one after the other. The join messages the
+// clients receive from the ones connected after them are consumed.
+func (h *testHarness) ConnectAll(usernames ...string) []*testClient {
+	h.t.Helper()
+
+	var clients []*testClient
+	for _, username := range usernames {
+		client := h.Connect(username)
+		for _, other := range clients {
+			other.ExpectServerMessage()
+		}
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// testClient is the client end of a pipe. Its packets are read in the
+// background, so the server never blocks writing to it, and pings are skipped.
+type testClient struct {
+	t       *testing.T
+	conn    *PipeConnection
+	packets chan *protocol.Packet
+
+	Account *account.Account
+}
+
+func (tc *testClient) readPackets() {
+	defer close(tc.packets)
+	for {
+		pkt, err := tc.conn.ReadPacket()
+		if err != nil {
+			return
+		}
+		switch pkt.Header.PacketType {
+		case protocol.PacketTypePing, protocol.PacketTypePong:
+			continue
+		}
+		tc.packets <- pkt
+	}
+}
+
+func (tc *testClient) Close() {
+	tc.conn.Close()
+}
+
+func (tc *testClient) Send(pkt *protocol.Packet) {
+	tc.t.Helper()
+	require.NoError(tc.t, tc.conn.WritePacket(pkt))
+}
+
+func (tc *testClient) SendMessage(content string) {
+	tc.t.Helper()
+	tc.Send(protocol.NewChatMessage(tc.Account, content, protocol.ChatRoom{}, time.Now()).ToPacket())
+}
+
+// SendCommand runs a command, written without the leading slash.
+func (tc *testClient) SendCommand(command string) {
+	tc.t.Helper()
+	msg := protocol.NewChatMessage(tc.Account, command, protocol.ChatRoom{}, time.Now())
+	msg.IsCommand = true
+	tc.Send(msg.ToPacket())
+}
+
+func (tc *testClient) Auth(username string, roomID id.ID) protocol.ServerAuthMessage {
+	tc.t.Helper()
+
+	tc.Send(protocol.ClientAuthMessage{Username: username, RoomID: roomID}.ToPacket())
+	res, err := protocol.ServerAuthMessageFromPacket(tc.Expect(protocol.PacketTypeAuth))
+	require.NoError(tc.t, err)
+	return res
+}
+
+// Expect returns the next packet, which must be of the given type.
+func (tc *testClient) Expect(pktType protocol.PacketType) *protocol.Packet {
+	tc.t.Helper()
+
+	select {
+	case pkt, ok := <-tc.packets:
+		require.True(tc.t, ok, "connection closed while waiting for a packet")
+		require.Equal(tc.t, pktType, pkt.Header.PacketType, "unexpected packet: %s", pkt.Payload)
+		return pkt
+	case <-time.After(packetTimeout):
+		require.FailNow(tc.t, "timed out waiting for a packet")
+		return nil
+	}
+}
+
+func (tc *testClient) ExpectMessage() protocol.ChatMessage {
+	tc.t.Helper()
+
+	msg, err := protocol.ChatMessageFromPacket(tc.Expect(protocol.PacketTypeMessage))
+	require.NoError(tc.t, err)
+	return msg
+}
+
+// ExpectChatMessage returns the next message, which must be sent by a client.
+func (tc *testClient) ExpectChatMessage() protocol.ChatMessage {
+	tc.t.Helper()
+
+	msg := tc.ExpectMessage()
+	require.False(tc.t, msg.IsServer || msg.IsCommand, "unexpected message: %s", msg.Content)
+	return msg
+}
+
+func (tc *testClient) ExpectServerMessage() protocol.ChatMessage {
+	tc.t.Helper()
+
+	msg := tc.ExpectMessage()
+	require.True(tc.t, msg.IsServer, "unexpected message: %s", msg.Content)
+	return msg
+}
+
+func (tc *testClient) ExpectCommandResponse() protocol.ChatMessage {
+	tc.t.Helper()
+
+	msg := tc.ExpectMessage()
+	require.True(tc.t, msg.IsCommand, "unexpected message: %s", msg.Content)
+	return msg
+}
+
+// ExpectNothing checks that no packet arrives for a short while.
+func (tc *testClient) ExpectNothing() {
+	tc.t.Helper()
+
+	select {
+	case pkt, ok := <-tc.packets:
+		if ok {
+			require.FailNow(tc.t, "unexpected packet", "%s", pkt.Payload)
+		}
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// ExpectClosed waits for the server to close the connection, skipping the
+// packets sent before it.
+func (tc *testClient) ExpectClosed() {
+	tc.t.Helper()
+
+	timeout := time.After(packetTimeout)
+	for {
+		select {
+		case _, ok := <-tc.packets:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			require.FailNow(tc.t, "timed out waiting for the connection to close")
+		}
+	}
+}
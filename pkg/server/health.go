@@ -0,0 +1,187 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultDrainDelay is how long Shutdown waits, with the readiness probe
+	// failing, before it stops accepting connections.
+	DefaultDrainDelay = 5 * time.Second
+	readinessTimeout  = 2 * time.Second
+)
+
+var ErrDraining = errors.New("server is shutting down")
+
+// ReadinessCheck reports whether a dependency of the server can be used.
+type ReadinessCheck func(ctx context.Context) error
+
+type readinessCheck struct {
+	name  string
+	check ReadinessCheck
+}
+
+type readinessResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// WithReadinessCheck adds a check to the readiness probe, which fails while the
+// check returns an error.
+func WithReadinessCheck(name string, check ReadinessCheck) Option {
+	return func(s *Server) {
+		s.readinessChecks = append(s.readinessChecks, readinessCheck{name: name, check: check})
+	}
+}
+
+// WithAdminToken protects /debug/stats and /metrics, which are open when no
+// token is set.
+// Clients that send the token with "/admin login <token>" become administrators.
+func WithAdminToken(token string) Option {
+	return func(s *Server) {
+		s.adminToken = token
+	}
+}
+
+// WithDrainDelay sets how long Shutdown waits before it stops accepting
+// connections, so load balancers see the readiness probe failing first.
+func WithDrainDelay(d time.Duration) Option {
+	return func(s *Server) {
+		s.drainDelay = d
+	}
+}
+
+// Shutdown stops the server gracefully. The readiness probe starts failing
+// right away, and after the drain delay the server stops accepting
+// connections, waits for the pending HTTP requests and disconnects every
+// client.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.draining.Store(true)
+
+	select {
+	case <-time.After(s.drainDelay):
+	case <-ctx.Done():
+	}
+
+	var err error
+	s.httpServerMutex.Lock()
+	httpServer := s.httpServer
+	s.httpServerMutex.Unlock()
+	if httpServer != nil {
+		err = httpServer.Shutdown(ctx)
+	}
+
+	// WebSocket connections are hijacked, so the HTTP server does not close
+	// them. Cancelling also stops the webhooks, with the posts in flight
+	s.cancel()
+	done := make(chan struct{})
+	go func() {
+		s.handlers.Wait()
+		if s.webhooks != nil {
+			s.webhooks.wait()
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return err
+}
+
+// RunHealth serves the liveness and readiness probes over plain HTTP on the
+// address until the server shuts down, so they can be checked without trusting
+// the certificate of the server.
+func (s *Server) RunHealth(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.ServeHealth(l)
+}
+
+// ServeHealth serves the probes of RunHealth on the listener.
+func (s *Server) ServeHealth(l net.Listener) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", s.handleHealthz)
+	mux.HandleFunc("GET /readyz", s.handleReadyz)
+	httpServer := &http.Server{Handler: mux}
+
+	stop := context.AfterFunc(s.ctx, func() { httpServer.Close() })
+	defer stop()
+	err := httpServer.Serve(l)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// handleHealthz is the liveness probe: the server is alive while it answers.
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+// handleReadyz is the readiness probe. It fails while the server shuts down
+// or while any readiness check fails.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	res := readinessResponse{Status: "ok"}
+	status := http.StatusOK
+
+	if s.draining.Load() {
+		res.Status = ErrDraining.Error()
+		writeJSON(w, http.StatusServiceUnavailable, res)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	res.Checks = make(map[string]string, len(s.readinessChecks))
+	for _, c := range s.readinessChecks {
+		res.Checks[c.name] = "ok"
+		if err := c.check(ctx); err != nil {
+			res.Checks[c.name] = err.Error()
+			res.Status = "unavailable"
+			status = http.StatusServiceUnavailable
+		}
+	}
+	writeJSON(w, status, res)
+}
+
+func (s *Server) handleDebugStats(w http.ResponseWriter, r *http.Request) {
+	if s.checkAdminToken(w, r) {
+		writeJSON(w, http.StatusOK, s.Stats())
+	}
+}
+
+// checkAdminToken reports whether the request has the admin token, answering
+// it with an error if not. Every request is allowed when no token is set.
+func (s *Server) checkAdminToken(w http.ResponseWriter, r *http.Request) bool {
+	if s.adminToken == "" {
+		return true
+	}
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !tokenEquals(s.adminToken, token) {
+		writeAPIError(w, http.StatusUnauthorized, ErrInvalidAPIToken)
+		return false
+	}
+	return true
+}
+
+// Check reports whether files can be written to the store.
+func (fs *FileStore) Check(ctx context.Context) error {
+	f, err := os.CreateTemp(fs.dir, ".readyz-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
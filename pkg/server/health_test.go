@@ -0,0 +1,73 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadyz(t *testing.T) {
+	var storageErr error
+	s := NewServer(
+		WithDrainDelay(0),
+		WithReadinessCheck("storage", func(ctx context.Context) error {
+			return storageErr
+		}),
+	)
+
+	rec := apiRequest(t, s, http.MethodGet, "/readyz", "", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	storageErr = errors.New("disk full")
+	rec = apiRequest(t, s, http.MethodGet, "/readyz", "", "")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Contains(t, rec.Body.String(), "disk full")
+
+	storageErr = nil
+	assert.NoError(t, s.Shutdown(context.Background()))
+	rec = apiRequest(t, s, http.MethodGet, "/readyz", "", "")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	// the server is still alive while it drains
+	rec = apiRequest(t, s, http.MethodGet, "/healthz", "", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestDebugStatsToken(t *testing.T) {
+	s := NewServer(WithAdminToken("secret"))
+
+	rec := apiRequest(t, s, http.MethodGet, "/debug/stats", "", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = apiRequest(t, s, http.MethodGet, "/debug/stats", "secret", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"rooms":1`)
+}
+
+func TestServeHealth(t *testing.T) {
+	s := NewServer(WithDrainDelay(0))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	done := make(chan error, 1)
+	go func() { done <- s.ServeHealth(l) }()
+
+	for _, path := range []string{"/healthz", "/readyz"} {
+		res, err := http.Get("http://" + l.Addr().String() + path)
+		require.NoError(t, err)
+		res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode, path)
+	}
+	// only the probes are served
+	res, err := http.Get("http://" + l.Addr().String() + "/debug/stats")
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	require.NoError(t, s.Shutdown(context.Background()))
+	assert.NoError(t, <-done)
+}
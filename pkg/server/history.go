@@ -0,0 +1,126 @@
+package server
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jnaraujo/letschat/pkg/id"
+	"github.com/jnaraujo/letschat/pkg/protocol"
+)
+
+const DefaultHistorySize = 500
+
+var ErrMessageNotFound = errors.New("message not found")
+
+// MessageHistory keeps the last messages sent to a room. Older messages are
+// dropped once the history is full.
+type MessageHistory struct {
+	messages []protocol.ChatMessage
+	size     int
+	mutex    sync.RWMutex
+}
+
+func NewMessageHistory(size int) *MessageHistory {
+	return &MessageHistory{
+		messages: make([]protocol.ChatMessage, 0, size),
+		size:     size,
+	}
+}
+
+func (h *MessageHistory) Add(msg protocol.ChatMessage) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if len(h.messages) >= h.size {
+		copy(h.messages, h.messages[1:])
+		h.messages = h.messages[:len(h.messages)-1]
+	}
+	h.messages = append(h.messages, msg)
+}
+
+func (h *MessageHistory) Find(id id.ID) (protocol.ChatMessage, bool) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	i := h.indexOf(id)
+	if i == -1 {
+		return protocol.ChatMessage{}, false
+	}
+	return h.messages[i], true
+}
+
+// Update calls fn with the stored message so it can be changed in place. If fn
+// returns an error the message is left untouched. The updated message is
+// returned.
+func (h *MessageHistory) Update(id id.ID, fn func(msg *protocol.ChatMessage) error) (protocol.ChatMessage, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	i := h.indexOf(id)
+	if i == -1 {
+		return protocol.ChatMessage{}, ErrMessageNotFound
+	}
+
+	msg := h.messages[i]
+	if err := fn(&msg); err != nil {
+		return h.messages[i], err
+	}
+	h.messages[i] = msg
+	return msg, nil
+}
+
+// List returns the last n messages, oldest first.
+func (h *MessageHistory) List(n int) []protocol.ChatMessage {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	n = min(n, len(h.messages))
+	messages := make([]protocol.ChatMessage, n)
+	copy(messages, h.messages[len(h.messages)-n:])
+	return messages
+}
+
+// Replies returns the messages that answer the message with the given ID,
+// oldest first.
+func (h *MessageHistory) Replies(id id.ID) []protocol.ChatMessage {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	var replies []protocol.ChatMessage
+	for _, msg := range h.messages {
+		if msg.ReplyTo == id {
+			replies = append(replies, msg)
+		}
+	}
+	return replies
+}
+
+// CountUnread counts the messages created after since that were not sent by
+// the account and were not deleted.
+func (h *MessageHistory) CountUnread(since time.Time, accountID id.ID) int {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	count := 0
+	for _, msg := range h.messages {
+		if !msg.CreatedAt.After(since) || msg.Deleted {
+			continue
+		}
+		if msg.Author != nil && msg.Author.ID == accountID {
+			continue
+		}
+		count++
+	}
+	return count
+}
+
+func (h *MessageHistory) indexOf(id id.ID) int {
+	// recent messages are the most likely to be looked up
+	for i := len(h.messages) - 1; i >= 0; i-- {
+		if h.messages[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jnaraujo/letschat/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+)
+
+// dialTestClient connects and authenticates a client to the test server.
+func dialTestClient(t *testing.T, ts *httptest.Server, username string) *websocket.Conn {
+	t.Helper()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/lc", nil)
+	require.NoError(t, err)
+
+	data, err := protocol.ClientAuthMessage{Username: username}.ToPacket().ToBinary()
+	require.NoError(t, err)
+	require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, data))
+
+	_, data, err = conn.ReadMessage()
+	require.NoError(t, err)
+	pkt, err := protocol.PacketFromBytes(data)
+	require.NoError(t, err)
+	res, err := protocol.ServerAuthMessageFromPacket(pkt)
+	require.NoError(t, err)
+	require.Equal(t, "ok", res.Status, res.Content)
+	return conn
+}
+
+// waitClosed reads from the connection until the server closes it.
+func waitClosed(t *testing.T, conn *websocket.Conn) {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			assert.NotErrorIs(t, err, os.ErrDeadlineExceeded, "connection was not closed")
+			return
+		}
+	}
+}
+
+func waitNoConnections(t *testing.T, s *Server) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		return s.connections.Load() == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestDisconnectDoesNotLeak(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	s := NewServer()
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	for _, username := range []string{"alice", "bobby", "carol"} {
+		conn := dialTestClient(t, ts, username)
+		conn.Close()
+	}
+	waitNoConnections(t, s)
+	assert.Empty(t, s.rooms.Find(defaultRoomID).Clients.List())
+}
+
+func TestKickDoesNotLeak(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	s := NewServer()
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	conn := dialTestClient(t, ts, "alice")
+	defer conn.Close()
+
+	client := s.rooms.Find(defaultRoomID).Clients.List()[0]
+	client.Disconnect()
+	assert.Error(t, client.Context().Err())
+
+	waitClosed(t, conn)
+	waitNoConnections(t, s)
+}
+
+func TestShutdownDisconnectsClients(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	s := NewServer(WithDrainDelay(0))
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	var conns []*websocket.Conn
+	for _, username := range []string{"alice", "bobby"} {
+		conn := dialTestClient(t, ts, username)
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, s.Shutdown(ctx))
+
+	assert.Zero(t, s.connections.Load())
+	for _, conn := range conns {
+		waitClosed(t, conn)
+	}
+}
+
+func TestShutdownStopsWebhooks(t *testing.T) {
+	// the receiver hangs, so a post is in flight when the server shuts down
+	posted := make(chan struct{}, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the context is only done on disconnect once the body is read
+		io.Copy(io.Discard, r.Body)
+		select {
+		case posted <- struct{}{}:
+		default:
+		}
+		<-r.Context().Done()
+	}))
+	defer receiver.Close()
+
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	h := newTestHarness(t, WithWebhooks(newTestWebhookSender(nil)))
+	alice := h.Connect("alice")
+	room := newTestRoom(t, h, alice, "games")
+	alice.SendCommand("join " + string(room.ID))
+	alice.ExpectServerMessage()
+	alice.SendCommand("webhook add " + receiver.URL)
+	assert.Contains(t, alice.ExpectCommandResponse().Content, "added")
+	alice.SendMessage("hello")
+	select {
+	case <-posted:
+	case <-time.After(packetTimeout):
+		t.Fatal("webhook did not post the message")
+	}
+	alice.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, h.server.Shutdown(ctx))
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/jnaraujo/letschat/pkg/id"
+	"github.com/jnaraujo/letschat/pkg/protocol"
+)
+
+// MaxMentions is the number of recent mentions kept for each account.
+const MaxMentions = 50
+
+// MentionList keeps the last messages that mentioned each account.
+type MentionList struct {
+	mentions map[id.ID][]protocol.ChatMessage
+	mutex    sync.RWMutex
+}
+
+func NewMentionList() *MentionList {
+	return &MentionList{
+		mentions: make(map[id.ID][]protocol.ChatMessage),
+	}
+}
+
+func (ml *MentionList) Add(accountID id.ID, msg protocol.ChatMessage) {
+	ml.mutex.Lock()
+	defer ml.mutex.Unlock()
+
+	mentions := append(ml.mentions[accountID], msg)
+	if len(mentions) > MaxMentions {
+		mentions = slices.Delete(mentions, 0, len(mentions)-MaxMentions)
+	}
+	ml.mentions[accountID] = mentions
+}
+
+// List returns the mentions of the account, oldest first.
+func (ml *MentionList) List(accountID id.ID) []protocol.ChatMessage {
+	ml.mutex.RLock()
+	defer ml.mutex.RUnlock()
+
+	return slices.Clone(ml.mentions[accountID])
+}
+
+func (ml *MentionList) Remove(accountID id.ID) {
+	ml.mutex.Lock()
+	defer ml.mutex.Unlock()
+
+	delete(ml.mentions, accountID)
+}
+
+// resolveMentions fills the mentions of a message with the clients of the room
+// it mentions by username. Usernames are matched ignoring case.
+func resolveMentions(room *Room, msg *protocol.ChatMessage) {
+	names := protocol.ParseMentions(msg.Content)
+	if len(names) == 0 {
+		return
+	}
+
+	for _, name := range names {
+		if strings.EqualFold(name, protocol.MentionRoom) {
+			msg.MentionsRoom = true
+		}
+	}
+
+	for _, client := range room.Clients.List() {
+		if client.Account().ID == msg.Author.ID {
+			continue
+		}
+		for _, name := range names {
+			if strings.EqualFold(name, client.Account().Username) {
+				msg.Mentions = append(msg.Mentions, client.Account().ID)
+				break
+			}
+		}
+	}
+}
+
+// notifyMentions records the message for every client of the room it
+// mentions.
+func (s *Server) notifyMentions(room *Room, msg protocol.ChatMessage) {
+	if !msg.MentionsRoom && len(msg.Mentions) == 0 {
+		return
+	}
+
+	for _, client := range room.Clients.List() {
+		if client.Account().ID == msg.Author.ID {
+			continue
+		}
+		if msg.IsMentioned(client.Account().ID) {
+			s.mentions.Add(client.Account().ID, msg)
+		}
+	}
+}
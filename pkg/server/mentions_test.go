@@ -0,0 +1,58 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jnaraujo/letschat/pkg/id"
+	"github.com/jnaraujo/letschat/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMentionListLimit(t *testing.T) {
+	ml := NewMentionList()
+	for i := range MaxMentions + 5 {
+		ml.Add("alice", protocol.NewCommandChatMessage(fmt.Sprint(i), time.Now()))
+	}
+
+	mentions := ml.List("alice")
+	assert.Len(t, mentions, MaxMentions)
+	// the oldest mentions are dropped
+	assert.Equal(t, "5", mentions[0].Content)
+	assert.Equal(t, fmt.Sprint(MaxMentions+4), mentions[len(mentions)-1].Content)
+
+	ml.Remove("alice")
+	assert.Empty(t, ml.List("alice"))
+}
+
+func TestMentions(t *testing.T) {
+	h := newTestHarness(t)
+	clients := h.ConnectAll("alice", "bobby", "carol")
+	alice, bobby, carol := clients[0], clients[1], clients[2]
+
+	alice.SendMessage("hi @BOBBY and @alice, not @dave or mail@carol")
+	msg := bobby.ExpectChatMessage()
+	// usernames match ignoring case, and authors do not mention themselves
+	assert.Equal(t, []id.ID{bobby.Account.ID}, msg.Mentions)
+	assert.False(t, msg.MentionsRoom)
+	carol.ExpectChatMessage()
+	alice.ExpectChatMessage()
+
+	alice.SendMessage("@room lunch?")
+	assert.True(t, bobby.ExpectChatMessage().MentionsRoom)
+	carol.ExpectChatMessage()
+	alice.ExpectChatMessage()
+
+	bobby.SendCommand("mentions")
+	assert.Equal(t, "==== 2 Recent Mentions ====", bobby.ExpectCommandResponse().Content)
+	assert.Contains(t, bobby.ExpectChatMessage().Content, "hi @BOBBY")
+	assert.Equal(t, "@room lunch?", bobby.ExpectChatMessage().Content)
+
+	carol.SendCommand("mentions")
+	assert.Equal(t, "==== 1 Recent Mention ====", carol.ExpectCommandResponse().Content)
+	carol.ExpectChatMessage()
+
+	alice.SendCommand("mentions")
+	assert.Equal(t, "Nobody mentioned you yet.", alice.ExpectCommandResponse().Content)
+}
@@ -0,0 +1,264 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jnaraujo/letschat/pkg/audit"
+	"github.com/jnaraujo/letschat/pkg/id"
+	"github.com/jnaraujo/letschat/pkg/protocol"
+	"github.com/jnaraujo/letschat/pkg/sanitize"
+)
+
+var (
+	ErrNotAllowed       = errors.New("you are not allowed to change this message")
+	ErrMessageDeleted   = errors.New("message was deleted")
+	ErrInvalidEmoji     = errors.New("invalid emoji")
+	ErrTooManyReactions = errors.New("message has too many reactions")
+)
+
+const (
+	maxEmojiLength         = 32
+	maxReactionsPerMessage = 20
+	// maxReactionAccounts limits the accounts listed in the reactions of a
+	// message, so it stays under protocol.MaxPayloadSize with the longest
+	// content.
+	maxReactionAccounts = 300
+)
+
+func (s *Server) handleMessageEdit(client *Client, pkt *protocol.Packet) {
+	edit, err := protocol.MessageEditFromPacket(pkt)
+	if err != nil {
+		slog.Error("error reading message edit", "err", err)
+		return
+	}
+
+	edit.Content, err = s.checkContent(edit.Content)
+	if errors.Is(err, ErrEmptyMessage) {
+		return
+	}
+	if err != nil {
+		sendCommandError(client, "Failed to edit message", err)
+		return
+	}
+
+	room := s.rooms.Find(client.RoomID())
+	if room == nil {
+		return
+	}
+
+	original, ok := room.History.Find(edit.MessageID)
+	if !ok {
+		sendCommandError(client, "Failed to edit message", ErrMessageNotFound)
+		return
+	}
+	if err := canModifyMessage(room, client, &original); err != nil {
+		sendCommandError(client, "Failed to edit message", err)
+		return
+	}
+	edited := original
+	edited.Content = edit.Content
+	if err := s.screenMessage(client, room, &edited, true); err != nil {
+		sendCommandError(client, "Edit rejected", err)
+		return
+	}
+	edit.Content = edited.Content
+
+	edit.RoomID = room.ID
+	edit.EditedBy = client.Account()
+	edit.EditedAt = time.Now()
+
+	_, err = room.History.Update(edit.MessageID, func(msg *protocol.ChatMessage) error {
+		if err := canModifyMessage(room, client, msg); err != nil {
+			return err
+		}
+		edit.Apply(msg)
+		return nil
+	})
+	if err != nil {
+		sendCommandError(client, "Failed to edit message", err)
+		return
+	}
+
+	room.BroadcastPacket(edit.ToPacket())
+}
+
+func (s *Server) handleMessageDelete(client *Client, pkt *protocol.Packet) {
+	del, err := protocol.MessageDeleteFromPacket(pkt)
+	if err != nil {
+		slog.Error("error reading message delete", "err", err)
+		return
+	}
+
+	room := s.rooms.Find(client.RoomID())
+	if room == nil {
+		return
+	}
+
+	del.RoomID = room.ID
+	del.DeletedBy = client.Account()
+	del.DeletedAt = time.Now()
+
+	msg, err := room.History.Update(del.MessageID, func(msg *protocol.ChatMessage) error {
+		if err := canModifyMessage(room, client, msg); err != nil {
+			return err
+		}
+		del.Apply(msg)
+		return nil
+	})
+	if err != nil {
+		sendCommandError(client, "Failed to delete message", err)
+		return
+	}
+	// only moderation is audited, not authors deleting their own messages
+	if msg.Author == nil || msg.Author.ID != client.Account().ID {
+		author := ""
+		if msg.Author != nil {
+			author = fmt.Sprintf("by %s (%s)", msg.Author.Username, msg.Author.ID)
+		}
+		s.audit(audit.MessageDelete, client, room, string(msg.ID), author)
+	}
+
+	room.BroadcastPacket(del.ToPacket())
+}
+
+func (s *Server) handleReaction(client *Client, pkt *protocol.Packet) {
+	reaction, err := protocol.ReactionFromPacket(pkt)
+	if err != nil {
+		slog.Error("error reading reaction", "err", err)
+		return
+	}
+
+	reaction.Emoji, err = sanitize.Line(reaction.Emoji)
+	if err != nil || reaction.Emoji == "" || len(reaction.Emoji) > maxEmojiLength ||
+		strings.ContainsFunc(reaction.Emoji, unicode.IsSpace) {
+		sendCommandError(client, "Failed to react to message", ErrInvalidEmoji)
+		return
+	}
+
+	room := s.rooms.Find(client.RoomID())
+	if room == nil {
+		return
+	}
+
+	reaction.RoomID = room.ID
+	reaction.Account = client.Account()
+
+	_, err = room.History.Update(reaction.MessageID, func(msg *protocol.ChatMessage) error {
+		if msg.Deleted {
+			return ErrMessageDeleted
+		}
+
+		// reacting twice with the same emoji removes the reaction
+		reaction.Added = !msg.HasReaction(reaction.Emoji, client.Account().ID)
+		if reaction.Added && msg.Reactions[reaction.Emoji] == nil &&
+			len(msg.Reactions) >= maxReactionsPerMessage {
+			return ErrTooManyReactions
+		}
+		if reaction.Added && msg.ReactionCount() >= maxReactionAccounts {
+			return ErrTooManyReactions
+		}
+		reaction.Apply(msg)
+		return nil
+	})
+	if err != nil {
+		sendCommandError(client, "Failed to react to message", err)
+		return
+	}
+
+	room.BroadcastPacket(reaction.ToPacket())
+}
+
+func (s *Server) handleTyping(client *Client, pkt *protocol.Packet) {
+	typing, err := protocol.TypingMessageFromPacket(pkt)
+	if err != nil {
+		slog.Error("error reading typing message", "err", err)
+		return
+	}
+
+	room := s.rooms.Find(client.RoomID())
+	if room == nil {
+		return
+	}
+
+	if typing.Typing && !client.Presence.StartTyping() {
+		return
+	}
+	if !typing.Typing && !client.Presence.StopTyping() {
+		return
+	}
+
+	typing.RoomID = room.ID
+	typing.Account = client.Account()
+	room.BroadcastPacket(typing.ToPacket())
+}
+
+func (s *Server) handleReadMarker(client *Client, pkt *protocol.Packet) {
+	marker, err := protocol.ReadMarkerFromPacket(pkt)
+	if err != nil {
+		slog.Error("error reading read marker", "err", err)
+		return
+	}
+
+	room := s.rooms.Find(client.RoomID())
+	if room == nil {
+		return
+	}
+
+	msg, ok := room.History.Find(marker.MessageID)
+	if !ok {
+		return
+	}
+
+	moved := s.readMarkers.Set(client.Account().ID, room.ID, ReadMarker{
+		MessageID: msg.ID,
+		CreatedAt: msg.CreatedAt,
+	})
+	if !moved || !marker.Receipt {
+		return
+	}
+
+	marker.RoomID = room.ID
+	marker.Account = client.Account()
+	marker.ReadAt = time.Now()
+	room.BroadcastPacket(marker.ToPacket())
+}
+
+// canModifyMessage checks whether the client can edit or delete the message.
+// Authors can change their own messages and moderators can change any message
+// of the room.
+func canModifyMessage(room *Room, client *Client, msg *protocol.ChatMessage) error {
+	if msg.Deleted {
+		return ErrMessageDeleted
+	}
+	if msg.Author != nil && msg.Author.ID == client.Account().ID {
+		return nil
+	}
+	if room.IsModerator(client.Account().ID) {
+		return nil
+	}
+	return ErrNotAllowed
+}
+
+// findReplyParent returns the message being replied to. It must be a message of
+// the room that was not deleted.
+func findReplyParent(room *Room, parentID id.ID) (protocol.ChatMessage, error) {
+	parent, ok := room.History.Find(parentID)
+	if !ok {
+		return parent, ErrMessageNotFound
+	}
+	if parent.Deleted {
+		return parent, ErrMessageDeleted
+	}
+	return parent, nil
+}
+
+func sendCommandError(client *Client, prefix string, err error) {
+	client.Conn.WritePacket(
+		protocol.NewCommandChatMessage(prefix+": "+err.Error(), time.Now()).ToPacket(),
+	)
+}
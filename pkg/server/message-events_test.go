@@ -0,0 +1,69 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jnaraujo/letschat/pkg/account"
+	"github.com/jnaraujo/letschat/pkg/id"
+	"github.com/jnaraujo/letschat/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReaction(t *testing.T) {
+	h := newTestHarness(t)
+	alice := h.Connect("alice")
+	alice.SendMessage("hello")
+	msg := alice.ExpectChatMessage()
+
+	alice.Send(protocol.Reaction{MessageID: msg.ID, Emoji: "👍"}.ToPacket())
+	reaction, err := protocol.ReactionFromPacket(alice.Expect(protocol.PacketTypeReaction))
+	require.NoError(t, err)
+	assert.True(t, reaction.Added)
+
+	// reacting again removes it
+	alice.Send(protocol.Reaction{MessageID: msg.ID, Emoji: "👍"}.ToPacket())
+	reaction, err = protocol.ReactionFromPacket(alice.Expect(protocol.PacketTypeReaction))
+	require.NoError(t, err)
+	assert.False(t, reaction.Added)
+}
+
+func TestReactionLimit(t *testing.T) {
+	h := newTestHarness(t)
+	alice := h.Connect("alice")
+	alice.SendMessage("hello")
+	msg := alice.ExpectChatMessage()
+
+	room := h.server.rooms.Find(defaultRoomID)
+	_, err := room.History.Update(msg.ID, func(msg *protocol.ChatMessage) error {
+		for range maxReactionAccounts {
+			msg.SetReaction("👍", id.NewID(22), true)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	alice.Send(protocol.Reaction{MessageID: msg.ID, Emoji: "👍"}.ToPacket())
+	assert.Contains(t, alice.ExpectCommandResponse().Content, ErrTooManyReactions.Error())
+}
+
+// TestLargestMessage checks that a message with the longest content and every
+// reaction it can have can still be sent.
+func TestLargestMessage(t *testing.T) {
+	// escaped by encoding/json as \u003c, the longest a character can take
+	content := strings.Repeat("<", MaxMessageLength)
+	msg := protocol.NewChatMessage(account.NewAccount("alice"), content, protocol.ChatRoom{ID: defaultRoomID}, time.Now())
+	msg.ReplyTo = id.NewID(22)
+	msg.Quote = &protocol.QuotedMessage{Author: msg.Author, Content: content[:200]}
+	msg.Attachment = &protocol.FileAttachment{ID: id.NewID(fileIDLength), Name: strings.Repeat("<", 255)}
+	for i := range maxReactionAccounts {
+		emoji := fmt.Sprintf("%02d%s", i%maxReactionsPerMessage, strings.Repeat("<", maxEmojiLength-2))
+		msg.SetReaction(emoji, id.NewID(22), true)
+	}
+
+	_, err := msg.ToPacket().ToBinary()
+	assert.NoError(t, err)
+}
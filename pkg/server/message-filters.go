@@ -0,0 +1,235 @@
+package server
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jnaraujo/letschat/pkg/id"
+)
+
+var (
+	ErrBlockedWord    = errors.New("the message has a blocked word")
+	ErrLinkNotAllowed = errors.New("links are not allowed")
+	ErrNotModerator   = errors.New("only moderators of the room can do this")
+)
+
+// MessageFilter checks the content of a message sent to a room before it is
+// posted. It returns the content to post, which may be rewritten, or an error
+// with the reason the message was rejected, which is sent back to the author.
+type MessageFilter func(room *Room, content string) (string, error)
+
+// WithMessageFilters adds filters to the server. Filters run in the order they
+// were added, each one receiving the content returned by the previous one.
+func WithMessageFilters(filters ...MessageFilter) Option {
+	return func(s *Server) {
+		s.filters = append(s.filters, filters...)
+	}
+}
+
+// WithWordFilter adds a word filter to the server and lets room moderators
+// change its words with the /filter command.
+func WithWordFilter(wf *WordFilter) Option {
+	return func(s *Server) {
+		s.wordFilter = wf
+		s.filters = append(s.filters, wf.Filter)
+	}
+}
+
+// filterMessage runs the content through every filter of the server.
+func (s *Server) filterMessage(room *Room, content string) (string, error) {
+	for _, filter := range s.filters {
+		var err error
+		content, err = filter(room, content)
+		if err != nil {
+			return "", err
+		}
+	}
+	return content, nil
+}
+
+// WordFilter blocks a list of words in every room, plus the words added to each
+// room by its moderators. Blocked words are masked, or the whole message is
+// rejected when Reject is set. Words are matched whole and ignoring case.
+type WordFilter struct {
+	Reject bool
+
+	words     []string
+	roomWords map[id.ID][]string
+	mutex     sync.RWMutex
+}
+
+func NewWordFilter(words []string, reject bool) *WordFilter {
+	wf := &WordFilter{
+		Reject:    reject,
+		roomWords: make(map[id.ID][]string),
+	}
+	for _, word := range words {
+		if word = normalizeFilterWord(word); word != "" {
+			wf.words = append(wf.words, word)
+		}
+	}
+	return wf
+}
+
+// AddRoomWord blocks the word in the room. It returns false if the word is
+// empty or already blocked.
+func (wf *WordFilter) AddRoomWord(roomID id.ID, word string) bool {
+	word = normalizeFilterWord(word)
+
+	wf.mutex.Lock()
+	defer wf.mutex.Unlock()
+
+	if word == "" || slices.Contains(wf.roomWords[roomID], word) {
+		return false
+	}
+	wf.roomWords[roomID] = append(wf.roomWords[roomID], word)
+	return true
+}
+
+// RemoveRoomWord unblocks a word blocked in the room. Words blocked in every
+// room cannot be removed.
+func (wf *WordFilter) RemoveRoomWord(roomID id.ID, word string) bool {
+	word = normalizeFilterWord(word)
+
+	wf.mutex.Lock()
+	defer wf.mutex.Unlock()
+
+	i := slices.Index(wf.roomWords[roomID], word)
+	if i == -1 {
+		return false
+	}
+	wf.roomWords[roomID] = slices.Delete(wf.roomWords[roomID], i, i+1)
+	return true
+}
+
+// RoomWords returns the words blocked only in the room.
+func (wf *WordFilter) RoomWords(roomID id.ID) []string {
+	wf.mutex.RLock()
+	defer wf.mutex.RUnlock()
+
+	return slices.Clone(wf.roomWords[roomID])
+}
+
+func (wf *WordFilter) Filter(room *Room, content string) (string, error) {
+	wf.mutex.RLock()
+	blocked := slices.Concat(wf.words, wf.roomWords[room.ID])
+	wf.mutex.RUnlock()
+
+	if len(blocked) == 0 {
+		return content, nil
+	}
+
+	var b strings.Builder
+	found := false
+	for len(content) > 0 {
+		start := strings.IndexFunc(content, isFilterWordChar)
+		if start == -1 {
+			b.WriteString(content)
+			break
+		}
+		end := strings.IndexFunc(content[start:], func(r rune) bool {
+			return !isFilterWordChar(r)
+		})
+		if end == -1 {
+			end = len(content)
+		} else {
+			end += start
+		}
+
+		b.WriteString(content[:start])
+		word := content[start:end]
+		if slices.Contains(blocked, strings.ToLower(word)) {
+			found = true
+			b.WriteString(strings.Repeat("*", utf8.RuneCountInString(word)))
+		} else {
+			b.WriteString(word)
+		}
+		content = content[end:]
+	}
+
+	if found && wf.Reject {
+		return "", ErrBlockedWord
+	}
+	return b.String(), nil
+}
+
+func normalizeFilterWord(word string) string {
+	return strings.ToLower(strings.TrimSpace(word))
+}
+
+func isFilterWordChar(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.IsMark(r)
+}
+
+// linkPattern matches URLs with a scheme, up to the end of their host, and
+// host names starting with www. like www.example.com/path. Other bare host
+// names are not matched, since they cannot be told apart from file names like
+// main.go or abbreviations like e.g.
+var linkPattern = regexp.MustCompile(
+	`(?i)[a-z][a-z0-9+.-]*://[^\s/?#()<>"'` + "`" + `,;]*|` +
+		`\bwww\.(?:[\p{L}\p{N}](?:[\p{L}\p{N}-]*[\p{L}\p{N}])?\.)+\p{L}{2,}`,
+)
+
+// LinkFilter rejects messages with links, except to the allowed hosts and
+// their subdomains. The raw content is scanned, so links in code, and links
+// that are not rendered as such, are found too.
+func LinkFilter(allowedHosts ...string) MessageFilter {
+	return func(room *Room, content string) (string, error) {
+		for _, link := range linkPattern.FindAllString(content, -1) {
+			host := link
+			if strings.Contains(link, "://") {
+				u, err := url.Parse(link)
+				if err != nil {
+					return "", ErrLinkNotAllowed
+				}
+				host = u.Hostname()
+			}
+			if !isAllowedHost(strings.TrimSuffix(host, "."), allowedHosts) {
+				return "", ErrLinkNotAllowed
+			}
+		}
+		return content, nil
+	}
+}
+
+func isAllowedHost(host string, allowedHosts []string) bool {
+	host = strings.ToLower(host)
+	for _, allowed := range allowedHosts {
+		allowed = strings.ToLower(allowed)
+		if host == allowed || strings.HasSuffix(host, "."+allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// RepeatFilter shortens runs of the same character longer than n, so
+// "hellooooooo" becomes "hellooo" with an n of 3. Whitespace is kept, so code
+// stays indented, and n is at least 3, so code blocks still work.
+func RepeatFilter(n int) MessageFilter {
+	n = max(n, 3)
+	return func(room *Room, content string) (string, error) {
+		var b strings.Builder
+		var last rune
+		count := 0
+		for _, r := range content {
+			if unicode.IsSpace(r) {
+				last, count = 0, 0
+			} else if r == last {
+				count++
+			} else {
+				last, count = r, 1
+			}
+			if count <= n {
+				b.WriteRune(r)
+			}
+		}
+		return b.String(), nil
+	}
+}
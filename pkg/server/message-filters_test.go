@@ -0,0 +1,94 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/jnaraujo/letschat/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWordFilter(t *testing.T) {
+	room := NewRoom("test", nil)
+	wf := NewWordFilter([]string{"darn"}, false)
+
+	got, err := wf.Filter(room, "Darn it, darned thing")
+	assert.NoError(t, err)
+	assert.Equal(t, "**** it, darned thing", got)
+
+	assert.True(t, wf.AddRoomWord(room.ID, "Heck"))
+	got, _ = wf.Filter(room, "what the heck")
+	assert.Equal(t, "what the ****", got)
+
+	// room words do not apply to other rooms
+	got, _ = wf.Filter(NewRoom("other", nil), "what the heck")
+	assert.Equal(t, "what the heck", got)
+
+	wf.Reject = true
+	_, err = wf.Filter(room, "darn")
+	assert.ErrorIs(t, err, ErrBlockedWord)
+}
+
+func TestLinkFilter(t *testing.T) {
+	filter := LinkFilter("example.com")
+	room := NewRoom("test", nil)
+
+	_, err := filter(room, "see https://evil.com/x")
+	assert.ErrorIs(t, err, ErrLinkNotAllowed)
+	_, err = filter(room, "see [this](https://docs.example.com/x)")
+	assert.NoError(t, err)
+	_, err = filter(room, "see https://example.com.evil.com")
+	assert.ErrorIs(t, err, ErrLinkNotAllowed)
+}
+
+func TestLinkFilterRawContent(t *testing.T) {
+	filter := LinkFilter("example.com")
+	room := NewRoom("test", nil)
+
+	for _, content := range []string{
+		"see `https://evil.com/x`",
+		"```\ncurl https://evil.com/x\n```",
+		"see www.evil.example/x",
+		"see WWW.EVIL.COM",
+		"see www.example.com.evil.com/x",
+		"see https://example.com.evil.com/x",
+		"see http://127.0.0.1:8080/x",
+		"see ftp://evil.com",
+		"see file:///etc/passwd",
+	} {
+		_, err := filter(room, content)
+		assert.ErrorIs(t, err, ErrLinkNotAllowed, content)
+	}
+
+	for _, content := range []string{
+		"see www.example.com/x and `https://docs.example.com`",
+		"see https://Example.COM:443/x, or https://example.com.",
+		"e.g. this, or that... ok.",
+		"version 1.2.3 is out",
+		// file names and bare host names are not links
+		"the bug is in main.go, see config.yaml",
+		"i.e. the README.md of evil.com",
+		"sent a file",
+	} {
+		_, err := filter(room, content)
+		assert.NoError(t, err, content)
+	}
+}
+
+func TestLinkFilterFileOffer(t *testing.T) {
+	files, err := NewFileStore(t.TempDir(), 1024, 1024)
+	require.NoError(t, err)
+	h := newTestHarness(t, WithFileStore(files), WithMessageFilters(LinkFilter()))
+	alice := h.Connect("alice")
+
+	alice.Send(protocol.FileOffer{Ref: "1", Name: "main.go", Size: 10}.ToPacket())
+	res, err := protocol.FileOfferResponseFromPacket(alice.Expect(protocol.PacketTypeFileOffer))
+	require.NoError(t, err)
+	assert.Equal(t, "ok", res.Status, res.Content)
+}
+
+func TestRepeatFilter(t *testing.T) {
+	filter := RepeatFilter(3)
+	got, _ := filter(nil, "hellooooooo!!!!!!        ok")
+	assert.Equal(t, "hellooo!!!        ok", got)
+}
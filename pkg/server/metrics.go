@@ -0,0 +1,187 @@
+package server
+
+import (
+	"cmp"
+	"errors"
+	"net/http"
+	"slices"
+
+	"github.com/jnaraujo/letschat/pkg/id"
+	"github.com/jnaraujo/letschat/pkg/protocol"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "letschat"
+
+var (
+	connectedClients = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "connected_clients",
+		Help:      "Number of open WebSocket connections, authenticated or not.",
+	})
+	messagesReceived = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "messages_received_total",
+		Help:      "Chat messages received and posted in a room.",
+	})
+	messagesBroadcast = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "messages_broadcast_total",
+		Help:      "Packets broadcast to every client of a room.",
+	})
+	commandsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "commands_total",
+		Help:      "Commands run, by name. Commands that do not exist are counted as unknown.",
+	}, []string{"command"})
+	authFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "auth_failures_total",
+		Help:      "Failed authentications, by reason.",
+	}, []string{"reason"})
+	writeErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "write_errors_total",
+		Help:      "Errors writing to WebSocket connections.",
+	})
+	packetSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "packet_size_bytes",
+		Help:      "Size of the packets read and written, by direction.",
+		Buckets:   prometheus.ExponentialBuckets(32, 4, 7),
+	}, []string{"direction"})
+	droppedPackets = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "send_queue_dropped_total",
+		Help:      "Packets dropped because the send queue of a client was full.",
+	})
+	slowConsumers = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "slow_consumers_total",
+		Help:      "Clients disconnected because their send queue was full.",
+	})
+	broadcastDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "broadcast_duration_seconds",
+		Help:      "Time taken to write a broadcast packet to every client of a room.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 8),
+	})
+)
+
+var (
+	roomsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "rooms"),
+		"Number of rooms.",
+		nil, nil,
+	)
+	// rooms are not labelled unless WithRoomMetrics is used: their IDs are
+	// what clients need to join them, and their names are chosen by clients
+	largestRoomDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "largest_room_clients"),
+		"Number of clients in the room with the most clients.",
+		nil, nil,
+	)
+	roomClientsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "room_clients"),
+		"Number of clients in each of the rooms with the most clients, by room ID.",
+		[]string{"room"}, nil,
+	)
+)
+
+// WithRoomMetrics reports the clients of the n rooms with the most clients in
+// the room_clients metric, labelled by room ID. Each room is a time series of
+// its own, so n bounds how many series rooms being created and deleted can
+// add, at the cost of not reporting the smaller rooms. Anyone who can scrape
+// the metrics learns the IDs of those rooms and can join them.
+func WithRoomMetrics(n int) Option {
+	return func(s *Server) {
+		s.roomMetrics = max(n, 0)
+	}
+}
+
+// roomCollector reports the rooms of a server and their clients when scraped.
+// The clients of the maxRooms rooms with the most clients are reported by room.
+type roomCollector struct {
+	rooms    *RoomList
+	maxRooms int
+}
+
+func (rc *roomCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- roomsDesc
+	ch <- largestRoomDesc
+	if rc.maxRooms > 0 {
+		ch <- roomClientsDesc
+	}
+}
+
+func (rc *roomCollector) Collect(ch chan<- prometheus.Metric) {
+	type roomClients struct {
+		id      id.ID
+		clients int
+	}
+	rooms := rc.rooms.List()
+	counts := make([]roomClients, 0, len(rooms))
+	for _, room := range rooms {
+		counts = append(counts, roomClients{id: room.ID, clients: len(room.Clients.List())})
+	}
+	slices.SortFunc(counts, func(a, b roomClients) int {
+		return cmp.Or(cmp.Compare(b.clients, a.clients), cmp.Compare(a.id, b.id))
+	})
+
+	largest := 0
+	if len(counts) > 0 {
+		largest = counts[0].clients
+	}
+	ch <- prometheus.MustNewConstMetric(roomsDesc, prometheus.GaugeValue, float64(len(rooms)))
+	ch <- prometheus.MustNewConstMetric(largestRoomDesc, prometheus.GaugeValue, float64(largest))
+	for _, room := range counts[:min(rc.maxRooms, len(counts))] {
+		ch <- prometheus.MustNewConstMetric(roomClientsDesc, prometheus.GaugeValue, float64(room.clients), string(room.id))
+	}
+}
+
+// metricsHandler serves the metrics of the server in the Prometheus format,
+// protected by the admin token like /debug/stats.
+func (s *Server) metricsHandler() http.Handler {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		connectedClients,
+		messagesReceived,
+		messagesBroadcast,
+		commandsTotal,
+		authFailures,
+		writeErrors,
+		packetSize,
+		broadcastDuration,
+		droppedPackets,
+		slowConsumers,
+		&roomCollector{rooms: s.rooms, maxRooms: s.roomMetrics},
+	)
+	metrics := promhttp.HandlerFor(reg, promhttp.HandlerOpts{})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.checkAdminToken(w, r) {
+			metrics.ServeHTTP(w, r)
+		}
+	})
+}
+
+// authFailureReason returns the reason label of a failed authentication.
+func authFailureReason(err error) string {
+	switch {
+	case errors.Is(err, ErrUsernameTaken):
+		return "username_taken"
+	case errors.Is(err, ErrUsernameTooShort),
+		errors.Is(err, ErrUsernameTooLong),
+		errors.Is(err, ErrInvalidUsername):
+		return "invalid_username"
+	case isVetoError(err):
+		return "vetoed"
+	case errors.Is(err, protocol.ErrProtocolVersionMismatch):
+		return "version_mismatch"
+	default:
+		return "other"
+	}
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsToken(t *testing.T) {
+	h := newTestHarness(t, WithAdminToken("secret"))
+
+	rec := apiRequest(t, h.server, http.MethodGet, "/metrics", "", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = apiRequest(t, h.server, http.MethodGet, "/metrics", "secret", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "letschat_rooms 1")
+}
+
+func TestMetricsRooms(t *testing.T) {
+	h := newTestHarness(t)
+	clients := h.ConnectAll("alice", "bobby")
+	room := newTestRoom(t, h, clients[0], "secret-room")
+
+	rec := apiRequest(t, h.server, http.MethodGet, "/metrics", "", "")
+	body := rec.Body.String()
+	assert.Contains(t, body, "letschat_rooms 2")
+	assert.Contains(t, body, "letschat_largest_room_clients 2")
+	// the rooms cannot be found from the metrics
+	assert.NotContains(t, body, string(room.ID))
+	assert.NotContains(t, body, "secret-room")
+}
+
+func TestMetricsRoomClients(t *testing.T) {
+	h := newTestHarness(t, WithRoomMetrics(1))
+	clients := h.ConnectAll("alice", "bobby")
+	room := newTestRoom(t, h, clients[0], "games")
+
+	rec := apiRequest(t, h.server, http.MethodGet, "/metrics", "", "")
+	body := rec.Body.String()
+	assert.Contains(t, body, `letschat_room_clients{room="`+string(defaultRoomID)+`"} 2`)
+	// only the largest room is reported
+	assert.NotContains(t, body, string(room.ID))
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jnaraujo/letschat/pkg/id"
+	"github.com/jnaraujo/letschat/pkg/sanitize"
+)
+
+// NamePolicy defines where usernames must be unique.
+type NamePolicy uint8
+
+const (
+	MinUsernameLength = 4
+	MaxUsernameLength = 15
+)
+
+const (
+	// UniqueNamesPerRoom allows the same username in different rooms.
+	UniqueNamesPerRoom NamePolicy = iota
+	// UniqueNamesPerServer allows each username only once in the whole server.
+	UniqueNamesPerServer
+)
+
+var (
+	ErrUsernameTooShort = errors.New("username is too short")
+	ErrUsernameTooLong  = errors.New("username is too long")
+	ErrUsernameTaken    = errors.New("username is already in use or too similar to one in use")
+	ErrInvalidUsername  = errors.New("invalid username")
+)
+
+// WithNamePolicy sets where usernames must be unique. By default they are
+// unique per room.
+func WithNamePolicy(policy NamePolicy) Option {
+	return func(s *Server) {
+		s.namePolicy = policy
+	}
+}
+
+// validateUsername checks the username and returns its normalized form, which
+// is the one that must be used.
+func validateUsername(username string) (string, error) {
+	username, err := sanitize.Username(username)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidUsername, err)
+	}
+	if sanitize.Length(username) < MinUsernameLength {
+		return "", ErrUsernameTooShort
+	}
+	if sanitize.Length(username) > MaxUsernameLength {
+		return "", ErrUsernameTooLong
+	}
+	return username, nil
+}
+
+// isUsernameError reports whether the error is caused by the username chosen by
+// the client, so it can be told why it was refused.
+func isUsernameError(err error) bool {
+	return errors.Is(err, ErrUsernameTooShort) ||
+		errors.Is(err, ErrUsernameTooLong) ||
+		errors.Is(err, ErrUsernameTaken) ||
+		errors.Is(err, ErrInvalidUsername)
+}
+
+// checkUsernameAvailable returns ErrUsernameTaken if another client in the room,
+// or in the whole server depending on the name policy, uses the username or one
+// that looks like it. It must be called with namesMutex held.
+func (s *Server) checkUsernameAvailable(username string, roomID, accountID id.ID) error {
+	var rooms []*Room
+	if s.namePolicy == UniqueNamesPerServer {
+		rooms = s.rooms.List()
+	} else if room := s.rooms.Find(roomID); room != nil {
+		rooms = []*Room{room}
+	}
+
+	for _, room := range rooms {
+		for _, client := range room.Clients.List() {
+			account := client.Account()
+			if account.ID != accountID && sanitize.Confusable(account.Username, username) {
+				return ErrUsernameTaken
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateUsername(t *testing.T) {
+	tests := []struct {
+		username string
+		want     string
+		err      error
+	}{
+		{"alice", "alice", nil},
+		{"abc", "", ErrUsernameTooShort},
+		{strings.Repeat("a", MaxUsernameLength+1), "", ErrUsernameTooLong},
+		{"bad name!", "", ErrInvalidUsername},
+	}
+	for _, tt := range tests {
+		got, err := validateUsername(tt.username)
+		assert.ErrorIs(t, err, tt.err, tt.username)
+		assert.Equal(t, tt.want, got, tt.username)
+		if tt.err != nil {
+			assert.True(t, isUsernameError(err), tt.username)
+		}
+	}
+}
+
+func TestUniqueNamesPerRoom(t *testing.T) {
+	h := newTestHarness(t, WithNamePolicy(UniqueNamesPerRoom))
+	alice := h.Connect("alice")
+	room := newTestRoom(t, h, alice, "games")
+
+	// the same name can be used in another room
+	h.ConnectRoom("bobby", room.ID)
+	h.Connect("bobby")
+	// but not a name that looks like one in the room
+	res := h.Dial().Auth("Alicé", "")
+	assert.Equal(t, "auth_error", res.Status)
+	assert.Contains(t, res.Content, ErrUsernameTaken.Error())
+}
+
+func TestUniqueNamesPerServer(t *testing.T) {
+	h := newTestHarness(t, WithNamePolicy(UniqueNamesPerServer))
+	alice := h.Connect("alice")
+	room := newTestRoom(t, h, alice, "games")
+	h.ConnectRoom("bobby", room.ID)
+
+	res := h.Dial().Auth("Bobby", "")
+	assert.Equal(t, "auth_error", res.Status)
+	assert.Contains(t, res.Content, ErrUsernameTaken.Error())
+
+	alice.SendCommand("nick bobby")
+	assert.Contains(t, alice.ExpectCommandResponse().Content, ErrUsernameTaken.Error())
+	assert.Equal(t, "alice", h.server.findClient(alice.Account.ID).Account().Username)
+}
+
+func TestNickCommand(t *testing.T) {
+	h := newTestHarness(t)
+	clients := h.ConnectAll("alice", "bobby")
+	alice, bobby := clients[0], clients[1]
+
+	alice.SendCommand("nick")
+	assert.Equal(t, "Usage: /nick <username>", alice.ExpectCommandResponse().Content)
+	alice.SendCommand("nick bad name!")
+	assert.Equal(t, "Usage: /nick <username>", alice.ExpectCommandResponse().Content)
+	alice.SendCommand("nick bad!")
+	assert.Contains(t, alice.ExpectCommandResponse().Content, ErrInvalidUsername.Error())
+
+	// a client can change the case of its own name
+	alice.SendCommand("nick Alice")
+	msg := bobby.ExpectServerMessage().Content
+	assert.Equal(t, "alice ("+string(alice.Account.ID)+") is now known as Alice", msg)
+	alice.ExpectServerMessage()
+	require.Equal(t, "Alice", h.server.findClient(alice.Account.ID).Account().Username)
+
+	// the old name is free again
+	alice.SendCommand("nick alicia")
+	bobby.ExpectServerMessage()
+	alice.ExpectServerMessage()
+	res := h.Dial().Auth("alice", "")
+	assert.Equal(t, "ok", res.Status, res.Content)
+}
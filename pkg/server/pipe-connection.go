@@ -0,0 +1,86 @@
+package server
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/jnaraujo/letschat/pkg/protocol"
+)
+
+// PipeConnection is one end of an in-memory connection. Writes block until the
+// other end reads them, like net.Pipe.
+type PipeConnection struct {
+	in  <-chan []byte
+	out chan<- []byte
+
+	// done and closeOnce are shared by both ends, so closing either end
+	// closes the pipe.
+	done      chan struct{}
+	closeOnce *sync.Once
+}
+
+// NewPipe returns the two ends of an in-memory connection. One end can be
+// served with Server.ServeConn while the other acts as the client, so the
+// server can be used without sockets.
+func NewPipe() (*PipeConnection, *PipeConnection) {
+	a, b := make(chan []byte), make(chan []byte)
+	done := make(chan struct{})
+	closeOnce := new(sync.Once)
+	return &PipeConnection{in: a, out: b, done: done, closeOnce: closeOnce},
+		&PipeConnection{in: b, out: a, done: done, closeOnce: closeOnce}
+}
+
+func (pc *PipeConnection) Write(data []byte) error {
+	select {
+	case <-pc.done:
+		return ErrConnectionClosed
+	default:
+	}
+
+	// the data can be shared with other connections, so the reader gets its
+	// own copy, like it would from a socket
+	select {
+	case pc.out <- bytes.Clone(data):
+		return nil
+	case <-pc.done:
+		return ErrConnectionClosed
+	}
+}
+
+func (pc *PipeConnection) Read() ([]byte, error) {
+	select {
+	case data := <-pc.in:
+		return data, nil
+	case <-pc.done:
+		return nil, ErrConnectionClosed
+	}
+}
+
+func (pc *PipeConnection) WritePacket(pkt *protocol.Packet) error {
+	data, err := pkt.ToBinary()
+	if err != nil {
+		return err
+	}
+	return pc.Write(data)
+}
+
+func (pc *PipeConnection) ReadPacket() (*protocol.Packet, error) {
+	data, err := pc.Read()
+	if err != nil {
+		return nil, err
+	}
+	return protocol.PacketFromBytes(data)
+}
+
+func (pc *PipeConnection) RemoteAddr() string {
+	return "pipe"
+}
+
+func (pc *PipeConnection) Ping() error {
+	return nil
+}
+
+func (pc *PipeConnection) Close() error {
+	pc.closeOnce.Do(func() { close(pc.done) })
+	return nil
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/jnaraujo/letschat/pkg/audit"
+	"github.com/jnaraujo/letschat/pkg/id"
+	"github.com/jnaraujo/letschat/pkg/protocol"
+)
+
+// Plugin extends the server. Init is called once, when the server is created
+// and fully set up, and can register commands. Besides Init, a plugin implements the hook
+// interfaces of the events it wants to handle, like MessageHook.
+//
+// Hooks run in the goroutine of the client that caused the event, so they must
+// not block. Hooks that return an error veto the event, and the error is shown
+// to the client. When a hook vetoes an event, the hooks of the plugins added
+// after it are not called.
+type Plugin interface {
+	Init(s *Server)
+}
+
+// ConnectEvent happens when a new connection arrives, before it is upgraded to
+// a WebSocket.
+type ConnectEvent struct {
+	Addr    string
+	Request *http.Request
+}
+
+// AuthEvent happens when a client authenticates. Hooks can change the username
+// and the room the client joins, which are still validated afterwards.
+type AuthEvent struct {
+	Client   *Client
+	Username string
+	RoomID   id.ID
+}
+
+// JoinEvent happens before a client joins a room, including the first room
+// joined after authenticating.
+type JoinEvent struct {
+	Client *Client
+	Room   *Room
+}
+
+// LeaveEvent happens after a client leaves a room.
+type LeaveEvent struct {
+	Client *Client
+	Room   *Room
+}
+
+// MessageEvent happens before a message is posted in a room, after it passes
+// the message filters. Hooks can change the message. Client is nil for
+// messages posted by bots through the HTTP API.
+//
+// Edits and file offers are messages too. For an edit, Edit is set and Message
+// has the new content, and only changes to the content are kept. For a file
+// offer, Message has the Attachment with the name, size and type of the file,
+// before it is uploaded.
+type MessageEvent struct {
+	Client  *Client
+	Room    *Room
+	Message *protocol.ChatMessage
+	Edit    bool
+}
+
+// CommandEvent happens before a command runs. Name is the command without the
+// leading slash and Args the rest of the message.
+type CommandEvent struct {
+	Client *Client
+	Name   string
+	Args   string
+}
+
+// DisconnectEvent happens after an authenticated client disconnects.
+type DisconnectEvent struct {
+	Client *Client
+}
+
+type ConnectHook interface {
+	OnConnect(ev *ConnectEvent) error
+}
+
+type AuthHook interface {
+	OnAuth(ev *AuthEvent) error
+}
+
+type JoinHook interface {
+	OnJoin(ev *JoinEvent) error
+}
+
+type LeaveHook interface {
+	OnLeave(ev *LeaveEvent)
+}
+
+type MessageHook interface {
+	OnMessage(ev *MessageEvent) error
+}
+
+type CommandHook interface {
+	OnCommand(ev *CommandEvent) error
+}
+
+type DisconnectHook interface {
+	OnDisconnect(ev *DisconnectEvent)
+}
+
+// WithPlugins adds plugins to the server. Their hooks are called in the order
+// the plugins were added.
+func WithPlugins(plugins ...Plugin) Option {
+	return func(s *Server) {
+		s.plugins = append(s.plugins, plugins...)
+	}
+}
+
+// RegisterCommand adds a command to the server. It panics if a command with
+// the same name already exists.
+func (s *Server) RegisterCommand(name string, handler CommandHandler) {
+	if _, ok := s.commands[name]; ok {
+		panic(fmt.Sprintf("command %q already registered", name))
+	}
+	s.commands[name] = handler
+}
+
+// Rooms returns the rooms of the server.
+func (s *Server) Rooms() *RoomList {
+	return s.rooms
+}
+
+// vetoError is the error returned when a hook vetoes an event.
+type vetoError struct {
+	err error
+}
+
+func (e *vetoError) Error() string {
+	return e.err.Error()
+}
+
+func (e *vetoError) Unwrap() error {
+	return e.err
+}
+
+func isVetoError(err error) bool {
+	var veto *vetoError
+	return errors.As(err, &veto)
+}
+
+// runHooks calls the hooks of type H of every plugin, stopping at the first one
+// that vetoes the event.
+func runHooks[H any](s *Server, call func(hook H) error) error {
+	for _, plugin := range s.plugins {
+		hook, ok := plugin.(H)
+		if !ok {
+			continue
+		}
+		if err := call(hook); err != nil {
+			return &vetoError{err: err}
+		}
+	}
+	return nil
+}
+
+// notifyHooks calls the hooks of type H of every plugin, for events that cannot
+// be vetoed.
+func notifyHooks[H any](s *Server, call func(hook H)) {
+	for _, plugin := range s.plugins {
+		if hook, ok := plugin.(H); ok {
+			call(hook)
+		}
+	}
+}
+
+func (s *Server) leaveRoom(client *Client, room *Room) {
+	room.RemoveClient(client.Account().ID)
+	s.audit(audit.RoomLeave, client, room, "", "")
+	notifyHooks(s, func(hook LeaveHook) {
+		hook.OnLeave(&LeaveEvent{Client: client, Room: room})
+	})
+}
@@ -0,0 +1,310 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jnaraujo/letschat/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errVetoed = errors.New("vetoed by the plugin")
+
+// testPlugin implements every hook, calling the function set for it, if any.
+type testPlugin struct {
+	init         func(s *Server)
+	onAuth       func(ev *AuthEvent) error
+	onJoin       func(ev *JoinEvent) error
+	onMessage    func(ev *MessageEvent) error
+	onCommand    func(ev *CommandEvent) error
+	onLeave      func(ev *LeaveEvent)
+	onDisconnect func(ev *DisconnectEvent)
+}
+
+func (p *testPlugin) Init(s *Server) {
+	if p.init != nil {
+		p.init(s)
+	}
+}
+
+func (p *testPlugin) OnAuth(ev *AuthEvent) error {
+	if p.onAuth != nil {
+		return p.onAuth(ev)
+	}
+	return nil
+}
+
+func (p *testPlugin) OnJoin(ev *JoinEvent) error {
+	if p.onJoin != nil {
+		return p.onJoin(ev)
+	}
+	return nil
+}
+
+func (p *testPlugin) OnMessage(ev *MessageEvent) error {
+	if p.onMessage != nil {
+		return p.onMessage(ev)
+	}
+	return nil
+}
+
+func (p *testPlugin) OnCommand(ev *CommandEvent) error {
+	if p.onCommand != nil {
+		return p.onCommand(ev)
+	}
+	return nil
+}
+
+func (p *testPlugin) OnLeave(ev *LeaveEvent) {
+	if p.onLeave != nil {
+		p.onLeave(ev)
+	}
+}
+
+func (p *testPlugin) OnDisconnect(ev *DisconnectEvent) {
+	if p.onDisconnect != nil {
+		p.onDisconnect(ev)
+	}
+}
+
+func TestPluginVetoAuth(t *testing.T) {
+	h := newTestHarness(t, WithPlugins(&testPlugin{
+		onAuth: func(ev *AuthEvent) error {
+			if ev.Username == "mallory" {
+				return errVetoed
+			}
+			return nil
+		},
+	}))
+
+	res := h.Dial().Auth("mallory", "")
+	assert.Equal(t, "auth_error", res.Status)
+	assert.Equal(t, errVetoed.Error(), res.Content)
+
+	h.Connect("alice")
+}
+
+func TestPluginVetoJoin(t *testing.T) {
+	h := newTestHarness(t, WithPlugins(&testPlugin{
+		onJoin: func(ev *JoinEvent) error {
+			if ev.Room.Name == "games" {
+				return errVetoed
+			}
+			return nil
+		},
+	}))
+	alice := h.Connect("alice")
+	room := newTestRoom(t, h, alice, "games")
+
+	alice.SendCommand("join " + string(room.ID))
+	assert.Equal(t, "Failed to join room: "+errVetoed.Error(), alice.ExpectCommandResponse().Content)
+	assert.Equal(t, defaultRoomID, h.server.findClient(alice.Account.ID).RoomID())
+}
+
+func TestPluginVetoMessage(t *testing.T) {
+	h := newTestHarness(t, WithPlugins(&testPlugin{
+		onMessage: func(ev *MessageEvent) error {
+			if strings.Contains(ev.Message.Content, "spam") {
+				return errVetoed
+			}
+			return nil
+		},
+	}))
+	clients := h.ConnectAll("alice", "bobby")
+	alice, bobby := clients[0], clients[1]
+
+	alice.SendMessage("buy spam")
+	assert.Equal(t, "Failed to send message: "+errVetoed.Error(), alice.ExpectCommandResponse().Content)
+	bobby.ExpectNothing()
+
+	alice.SendMessage("hello")
+	assert.Equal(t, "hello", bobby.ExpectChatMessage().Content)
+}
+
+func TestPluginVetoEdit(t *testing.T) {
+	h := newTestHarness(t, WithPlugins(&testPlugin{
+		onMessage: func(ev *MessageEvent) error {
+			if strings.Contains(ev.Message.Content, "spam") {
+				return errVetoed
+			}
+			return nil
+		},
+	}))
+	clients := h.ConnectAll("alice", "bobby")
+	alice, bobby := clients[0], clients[1]
+
+	alice.SendMessage("ham")
+	msg := bobby.ExpectChatMessage()
+	alice.ExpectChatMessage()
+
+	// editing cannot be used to get around the hooks
+	alice.Send(protocol.MessageEdit{MessageID: msg.ID, Content: "buy spam"}.ToPacket())
+	assert.Equal(t, "Edit rejected: "+errVetoed.Error(), alice.ExpectCommandResponse().Content)
+	bobby.ExpectNothing()
+	stored, ok := h.server.rooms.Find(defaultRoomID).History.Find(msg.ID)
+	require.True(t, ok)
+	assert.Equal(t, "ham", stored.Content)
+}
+
+func TestPluginVetoFileOffer(t *testing.T) {
+	files, err := NewFileStore(t.TempDir(), 1024, 1024)
+	require.NoError(t, err)
+	h := newTestHarness(t, WithFileStore(files), WithPlugins(&testPlugin{
+		onMessage: func(ev *MessageEvent) error {
+			if ev.Message.Attachment != nil && strings.HasSuffix(ev.Message.Attachment.Name, ".exe") {
+				return errVetoed
+			}
+			return nil
+		},
+	}))
+	alice := h.Connect("alice")
+
+	alice.Send(protocol.FileOffer{Ref: "1", Name: "setup.exe", Size: 10}.ToPacket())
+	res, err := protocol.FileOfferResponseFromPacket(alice.Expect(protocol.PacketTypeFileOffer))
+	require.NoError(t, err)
+	assert.Equal(t, "error", res.Status)
+	assert.Equal(t, errVetoed.Error(), res.Content)
+
+	alice.Send(protocol.FileOffer{Ref: "2", Name: "notes.txt", Size: 10}.ToPacket())
+	res, err = protocol.FileOfferResponseFromPacket(alice.Expect(protocol.PacketTypeFileOffer))
+	require.NoError(t, err)
+	assert.Equal(t, "ok", res.Status, res.Content)
+}
+
+func TestPluginVetoCommand(t *testing.T) {
+	h := newTestHarness(t, WithPlugins(&testPlugin{
+		onCommand: func(ev *CommandEvent) error {
+			if ev.Name == "ls" {
+				return errVetoed
+			}
+			return nil
+		},
+	}))
+	alice := h.Connect("alice")
+
+	alice.SendCommand("ls")
+	assert.Equal(t, "Command refused: "+errVetoed.Error(), alice.ExpectCommandResponse().Content)
+}
+
+func TestPluginMutatingHooks(t *testing.T) {
+	h := newTestHarness(t, WithPlugins(&testPlugin{
+		onAuth: func(ev *AuthEvent) error {
+			ev.Username = "guest_" + ev.Username
+			return nil
+		},
+		onMessage: func(ev *MessageEvent) error {
+			ev.Message.Content = strings.ToUpper(ev.Message.Content)
+			return nil
+		},
+	}))
+	clients := h.ConnectAll("alice", "bobby")
+	alice, bobby := clients[0], clients[1]
+	assert.Equal(t, "guest_alice", alice.Account.Username)
+
+	alice.SendMessage("hello")
+	msg := bobby.ExpectChatMessage()
+	assert.Equal(t, "HELLO", msg.Content)
+	assert.Equal(t, "guest_alice", msg.Author.Username)
+	alice.ExpectChatMessage()
+
+	alice.Send(protocol.MessageEdit{MessageID: msg.ID, Content: "bye"}.ToPacket())
+	edit, err := protocol.MessageEditFromPacket(bobby.Expect(protocol.PacketTypeMessageEdit))
+	require.NoError(t, err)
+	assert.Equal(t, "BYE", edit.Content)
+}
+
+func TestPluginOrder(t *testing.T) {
+	var mutex sync.Mutex
+	var calls []string
+	record := func(name string, err error) func(ev *CommandEvent) error {
+		return func(ev *CommandEvent) error {
+			mutex.Lock()
+			defer mutex.Unlock()
+			calls = append(calls, name+" "+ev.Name)
+			if ev.Name == "ls" {
+				return err
+			}
+			return nil
+		}
+	}
+	h := newTestHarness(t, WithPlugins(
+		&testPlugin{onCommand: record("first", nil)},
+		&testPlugin{onCommand: record("second", errVetoed)},
+		&testPlugin{onCommand: record("third", nil)},
+	))
+	alice := h.Connect("alice")
+
+	alice.SendCommand("ping")
+	alice.ExpectCommandResponse()
+	// the plugins after the one that vetoed are not called
+	alice.SendCommand("ls")
+	alice.ExpectCommandResponse()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Equal(t, []string{
+		"first ping", "second ping", "third ping",
+		"first ls", "second ls",
+	}, calls)
+}
+
+func TestPluginNotifyHooks(t *testing.T) {
+	leaves := make(chan *LeaveEvent, 2)
+	disconnects := make(chan *DisconnectEvent, 1)
+	h := newTestHarness(t, WithPlugins(&testPlugin{
+		onLeave:      func(ev *LeaveEvent) { leaves <- ev },
+		onDisconnect: func(ev *DisconnectEvent) { disconnects <- ev },
+	}))
+	alice := h.Connect("alice")
+	room := newTestRoom(t, h, alice, "games")
+
+	alice.SendCommand("join " + string(room.ID))
+	alice.ExpectServerMessage()
+	ev := <-leaves
+	assert.Equal(t, defaultRoomID, ev.Room.ID)
+	assert.Equal(t, alice.Account.ID, ev.Client.Account().ID)
+
+	alice.Close()
+	ev = <-leaves
+	assert.Equal(t, room.ID, ev.Room.ID)
+	assert.Equal(t, alice.Account.ID, (<-disconnects).Client.Account().ID)
+}
+
+func TestRegisterCommand(t *testing.T) {
+	h := newTestHarness(t, WithPlugins(&testPlugin{
+		init: func(s *Server) {
+			s.RegisterCommand("hello", func(props *CommandProps) {
+				sendCommandReply(props.MessageAuthor, "hello "+props.MessageAuthor.Account().Username)
+			})
+		},
+	}))
+	alice := h.Connect("alice")
+
+	alice.SendCommand("hello")
+	assert.Equal(t, "hello alice", alice.ExpectCommandResponse().Content)
+}
+
+func TestPluginInit(t *testing.T) {
+	var defaultRoom *Room
+	NewServer(WithPlugins(&testPlugin{
+		init: func(s *Server) {
+			defaultRoom = s.Rooms().Find(defaultRoomID)
+		},
+	}))
+	assert.NotNil(t, defaultRoom, "the default room is created before Init")
+}
+
+func TestRegisterCommandClash(t *testing.T) {
+	for _, name := range []string{"ls", "join", "admin"} {
+		require.Panics(t, func() {
+			NewServer(WithPlugins(&testPlugin{
+				init: func(s *Server) {
+					s.RegisterCommand(name, func(props *CommandProps) {})
+				},
+			}))
+		}, name)
+	}
+}
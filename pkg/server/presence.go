@@ -0,0 +1,91 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jnaraujo/letschat/pkg/protocol"
+)
+
+const (
+	// AwayAfter is how long a client can go without sending messages before
+	// being shown as away.
+	AwayAfter = 5 * time.Minute
+	// TypingInterval is the minimum time between two typing notifications of
+	// the same client.
+	TypingInterval = 3 * time.Second
+	MaxStatusText  = 50
+)
+
+type Presence struct {
+	status       protocol.Status
+	text         string
+	lastActiveAt time.Time
+	typing       bool
+	lastTypingAt time.Time
+	mutex        sync.Mutex
+}
+
+func NewPresence() *Presence {
+	return &Presence{
+		status:       protocol.StatusOnline,
+		lastActiveAt: time.Now(),
+	}
+}
+
+func (p *Presence) Set(status protocol.Status, text string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.status = status
+	p.text = text
+}
+
+// Status returns the status shown to other clients. Online clients that did not
+// send a message for AwayAfter are shown as away.
+func (p *Presence) Status() (protocol.Status, string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.status == protocol.StatusOnline && time.Since(p.lastActiveAt) > AwayAfter {
+		return protocol.StatusAway, p.text
+	}
+	return p.status, p.text
+}
+
+// Touch marks the client as active. Sending a message also means the client
+// stopped typing.
+func (p *Presence) Touch() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.lastActiveAt = time.Now()
+	p.typing = false
+}
+
+// StartTyping reports whether the typing notification should be sent to the
+// room. Notifications are sent at most once every TypingInterval.
+func (p *Presence) StartTyping() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if time.Since(p.lastTypingAt) < TypingInterval {
+		return false
+	}
+	p.typing = true
+	p.lastTypingAt = time.Now()
+	return true
+}
+
+// StopTyping reports whether the stop notification should be sent to the room,
+// which only happens if the start was sent.
+func (p *Presence) StopTyping() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.typing {
+		return false
+	}
+	p.typing = false
+	return true
+}
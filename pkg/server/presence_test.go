@@ -0,0 +1,93 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jnaraujo/letschat/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPresenceAway(t *testing.T) {
+	p := NewPresence()
+	status, _ := p.Status()
+	assert.Equal(t, protocol.StatusOnline, status)
+
+	p.lastActiveAt = time.Now().Add(-AwayAfter - time.Second)
+	status, _ = p.Status()
+	assert.Equal(t, protocol.StatusAway, status)
+
+	// a status other than online is kept while inactive
+	p.Set(protocol.StatusDoNotDisturb, "focus")
+	status, text := p.Status()
+	assert.Equal(t, protocol.StatusDoNotDisturb, status)
+	assert.Equal(t, "focus", text)
+
+	p.Set(protocol.StatusOnline, "")
+	p.Touch()
+	status, _ = p.Status()
+	assert.Equal(t, protocol.StatusOnline, status)
+}
+
+func TestPresenceTyping(t *testing.T) {
+	p := NewPresence()
+	assert.False(t, p.StopTyping(), "stop without start")
+
+	assert.True(t, p.StartTyping())
+	assert.False(t, p.StartTyping(), "start within TypingInterval")
+	assert.True(t, p.StopTyping())
+	assert.False(t, p.StopTyping())
+
+	p.lastTypingAt = time.Now().Add(-TypingInterval)
+	assert.True(t, p.StartTyping())
+	// sending a message stops typing
+	p.Touch()
+	assert.False(t, p.StopTyping())
+}
+
+func TestStatusCommand(t *testing.T) {
+	h := newTestHarness(t)
+	alice := h.Connect("alice")
+
+	alice.SendCommand("status")
+	assert.Equal(t, "Your status is online", alice.ExpectCommandResponse().Content)
+
+	alice.SendCommand("status dnd in a meeting")
+	assert.Equal(t, "Your status is now dnd (in a meeting)", alice.ExpectCommandResponse().Content)
+	alice.SendCommand("ls")
+	assert.Contains(t, alice.ExpectCommandResponse().Content, "dnd (in a meeting)")
+
+	alice.SendCommand("status busy")
+	assert.Contains(t, alice.ExpectCommandResponse().Content, "Usage: /status")
+
+	alice.SendCommand("status away " + strings.Repeat("a", MaxStatusText+1))
+	assert.Contains(t, alice.ExpectCommandResponse().Content, "at most")
+	status, text := h.server.findClient(alice.Account.ID).Presence.Status()
+	assert.Equal(t, protocol.StatusDoNotDisturb, status)
+	assert.Equal(t, "in a meeting", text)
+}
+
+func TestTypingNotifications(t *testing.T) {
+	h := newTestHarness(t)
+	clients := h.ConnectAll("alice", "bobby")
+	alice, bobby := clients[0], clients[1]
+
+	alice.Send(protocol.TypingMessage{Typing: true}.ToPacket())
+	typing, err := protocol.TypingMessageFromPacket(bobby.Expect(protocol.PacketTypeTyping))
+	require.NoError(t, err)
+	assert.True(t, typing.Typing)
+	assert.Equal(t, alice.Account.ID, typing.Account.ID)
+	assert.Equal(t, defaultRoomID, typing.RoomID)
+	alice.Expect(protocol.PacketTypeTyping)
+
+	// repeated notifications are throttled
+	alice.Send(protocol.TypingMessage{Typing: true}.ToPacket())
+	bobby.ExpectNothing()
+
+	alice.Send(protocol.TypingMessage{Typing: false}.ToPacket())
+	typing, err = protocol.TypingMessageFromPacket(bobby.Expect(protocol.PacketTypeTyping))
+	require.NoError(t, err)
+	assert.False(t, typing.Typing)
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jnaraujo/letschat/pkg/id"
+)
+
+type ReadMarker struct {
+	MessageID id.ID
+	// CreatedAt is the creation time of the marked message. Unread messages are
+	// counted from it, so the marker still works after the message leaves the
+	// room history.
+	CreatedAt time.Time
+}
+
+// ReadMarkerList keeps, for each account, the last message read in each room.
+type ReadMarkerList struct {
+	markers map[id.ID]map[id.ID]ReadMarker
+	mutex   sync.RWMutex
+}
+
+func NewReadMarkerList() *ReadMarkerList {
+	return &ReadMarkerList{
+		markers: make(map[id.ID]map[id.ID]ReadMarker),
+	}
+}
+
+// Set moves the marker of the account in the room. Markers only move forward,
+// so it returns false if the account already read a newer message.
+func (rml *ReadMarkerList) Set(accountID, roomID id.ID, marker ReadMarker) bool {
+	rml.mutex.Lock()
+	defer rml.mutex.Unlock()
+
+	rooms, ok := rml.markers[accountID]
+	if !ok {
+		rooms = make(map[id.ID]ReadMarker)
+		rml.markers[accountID] = rooms
+	}
+
+	if current, ok := rooms[roomID]; ok && current.CreatedAt.After(marker.CreatedAt) {
+		return false
+	}
+	rooms[roomID] = marker
+	return true
+}
+
+func (rml *ReadMarkerList) Find(accountID, roomID id.ID) (ReadMarker, bool) {
+	rml.mutex.RLock()
+	defer rml.mutex.RUnlock()
+
+	marker, ok := rml.markers[accountID][roomID]
+	return marker, ok
+}
+
+// Rooms returns the markers of the account, keyed by room ID.
+func (rml *ReadMarkerList) Rooms(accountID id.ID) map[id.ID]ReadMarker {
+	rml.mutex.RLock()
+	defer rml.mutex.RUnlock()
+
+	rooms := make(map[id.ID]ReadMarker, len(rml.markers[accountID]))
+	for roomID, marker := range rml.markers[accountID] {
+		rooms[roomID] = marker
+	}
+	return rooms
+}
+
+func (rml *ReadMarkerList) Remove(accountID id.ID) {
+	rml.mutex.Lock()
+	defer rml.mutex.Unlock()
+
+	delete(rml.markers, accountID)
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jnaraujo/letschat/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadMarkerList(t *testing.T) {
+	rml := NewReadMarkerList()
+	now := time.Now()
+
+	assert.True(t, rml.Set("alice", "room", ReadMarker{MessageID: "b", CreatedAt: now}))
+	// markers only move forward
+	assert.False(t, rml.Set("alice", "room", ReadMarker{MessageID: "a", CreatedAt: now.Add(-time.Second)}))
+	marker, ok := rml.Find("alice", "room")
+	require.True(t, ok)
+	assert.Equal(t, "b", string(marker.MessageID))
+
+	assert.True(t, rml.Set("alice", "other", ReadMarker{MessageID: "c", CreatedAt: now}))
+	rooms := rml.Rooms("alice")
+	assert.Len(t, rooms, 2)
+	// the returned map is a copy
+	delete(rooms, "room")
+	_, ok = rml.Find("alice", "room")
+	assert.True(t, ok)
+
+	rml.Remove("alice")
+	assert.Empty(t, rml.Rooms("alice"))
+}
+
+func TestReadReceipts(t *testing.T) {
+	h := newTestHarness(t)
+	clients := h.ConnectAll("alice", "bobby")
+	alice, bobby := clients[0], clients[1]
+
+	alice.SendMessage("first")
+	first := bobby.ExpectChatMessage()
+	alice.ExpectChatMessage()
+	alice.SendMessage("second")
+	second := bobby.ExpectChatMessage()
+	alice.ExpectChatMessage()
+
+	// without a receipt the marker moves silently
+	bobby.Send(protocol.ReadMarker{MessageID: first.ID}.ToPacket())
+	alice.ExpectNothing()
+
+	bobby.Send(protocol.ReadMarker{MessageID: second.ID, Receipt: true}.ToPacket())
+	receipt, err := protocol.ReadMarkerFromPacket(alice.Expect(protocol.PacketTypeReadMarker))
+	require.NoError(t, err)
+	assert.Equal(t, second.ID, receipt.MessageID)
+	assert.Equal(t, bobby.Account.ID, receipt.Account.ID)
+	assert.Equal(t, defaultRoomID, receipt.RoomID)
+	bobby.Expect(protocol.PacketTypeReadMarker)
+
+	// an older message does not move the marker back, so there is no receipt
+	bobby.Send(protocol.ReadMarker{MessageID: first.ID, Receipt: true}.ToPacket())
+	alice.ExpectNothing()
+	marker, ok := h.server.readMarkers.Find(bobby.Account.ID, defaultRoomID)
+	require.True(t, ok)
+	assert.Equal(t, second.ID, marker.MessageID)
+
+	// unknown messages are ignored
+	bobby.Send(protocol.ReadMarker{MessageID: "unknown", Receipt: true}.ToPacket())
+	alice.ExpectNothing()
+}
+
+func TestUnreadCommand(t *testing.T) {
+	h := newTestHarness(t)
+	clients := h.ConnectAll("alice", "bobby")
+	alice, bobby := clients[0], clients[1]
+
+	bobby.SendCommand("unread")
+	assert.Contains(t, bobby.ExpectCommandResponse().Content, "No unread messages")
+
+	alice.SendMessage("first")
+	alice.SendMessage("second")
+	bobby.ExpectChatMessage()
+	second := bobby.ExpectChatMessage()
+	alice.ExpectChatMessage()
+	alice.ExpectChatMessage()
+
+	bobby.SendCommand("unread")
+	assert.Contains(t, bobby.ExpectCommandResponse().Content, "2 unread messages")
+	// messages of the account itself are not unread
+	alice.SendCommand("unread")
+	assert.Contains(t, alice.ExpectCommandResponse().Content, "No unread messages")
+
+	bobby.Send(protocol.ReadMarker{MessageID: second.ID}.ToPacket())
+	bobby.SendCommand("unread")
+	assert.Contains(t, bobby.ExpectCommandResponse().Content, "No unread messages")
+}
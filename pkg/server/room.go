@@ -1,13 +1,23 @@
 package server
 
 import (
+	"errors"
 	"fmt"
+	"log/slog"
 	"sync"
 	"time"
 
 	"github.com/jnaraujo/letschat/pkg/account"
 	"github.com/jnaraujo/letschat/pkg/id"
 	"github.com/jnaraujo/letschat/pkg/protocol"
+	"github.com/jnaraujo/letschat/pkg/sanitize"
+)
+
+const MaxRoomNameLength = 32
+
+var (
+	ErrInvalidRoomName = errors.New("invalid room name")
+	ErrRoomNotFound    = errors.New("room not found")
 )
 
 type Room struct {
@@ -15,31 +25,56 @@ type Room struct {
 	Name    string
 	Owner   *account.Account
 	Clients *ClientList
+	History *MessageHistory
+	// Webhooks receive the messages, joins and leaves of the room.
+	Webhooks *WebhookList
+
+	// postMutex keeps the history in the order the messages are broadcast,
+	// and broadcastMutex makes every client receive the broadcasts in the
+	// same order.
+	postMutex      sync.Mutex
+	broadcastMutex sync.Mutex
 }
 
 func NewRoom(name string, owner *account.Account) *Room {
 	return &Room{
-		ID:      id.NewID(22),
-		Name:    name,
-		Owner:   owner,
-		Clients: NewClientList(),
+		ID:       id.NewID(22),
+		Name:     name,
+		Owner:    owner,
+		Clients:  NewClientList(),
+		History:  NewMessageHistory(DefaultHistorySize),
+		Webhooks: NewWebhookList(),
+	}
+}
+
+// validateRoomName checks the name of a new room and returns its sanitized
+// form.
+func validateRoomName(name string) (string, error) {
+	name, err := sanitize.Line(name)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidRoomName, err)
 	}
+	if name == "" || sanitize.Length(name) > MaxRoomNameLength {
+		return "", fmt.Errorf("%w: it must have from 1 to %d characters", ErrInvalidRoomName, MaxRoomNameLength)
+	}
+	return name, nil
 }
 
 func (r *Room) AddClient(client *Client) {
-	client.RoomID = r.ID
+	client.SetRoomID(r.ID)
 	r.Clients.Add(client)
 
 	r.Broadcast(protocol.NewServerChatMessage(
 		fmt.Sprintf(
-			"%s (%s) joined the chat", client.Account.Username, client.Account.ID,
+			"%s (%s) joined the chat", client.Account().Username, client.Account().ID,
 		),
-		protocol.ChatRoom{
-			ID:   r.ID,
-			Name: r.Name,
-		},
+		r.ChatRoom(),
 		time.Now(),
 	))
+
+	ev := newWebhookEvent(WebhookEventJoin, r.ChatRoom())
+	ev.Account = client.Account()
+	r.Webhooks.Send(ev)
 }
 
 func (r *Room) RemoveClient(id id.ID) {
@@ -52,25 +87,71 @@ func (r *Room) RemoveClient(id id.ID) {
 	r.Broadcast(protocol.NewServerChatMessage(
 		fmt.Sprintf(
 			"%s (%s) left the chat",
-			client.Account.Username, client.Account.ID,
+			client.Account().Username, client.Account().ID,
 		),
-		protocol.ChatRoom{
-			ID:   r.ID,
-			Name: r.Name,
-		},
+		r.ChatRoom(),
 		time.Now(),
 	))
+
+	ev := newWebhookEvent(WebhookEventLeave, r.ChatRoom())
+	ev.Account = client.Account()
+	r.Webhooks.Send(ev)
 }
 
 func (r *Room) HasClient(id id.ID) bool {
 	return r.Clients.Has(id)
 }
 
+func (r *Room) ChatRoom() protocol.ChatRoom {
+	return protocol.ChatRoom{
+		ID:   r.ID,
+		Name: r.Name,
+	}
+}
+
+// IsModerator reports whether the account can moderate the messages of the
+// room. Only the room owner is a moderator.
+func (r *Room) IsModerator(accountID id.ID) bool {
+	return r.Owner != nil && r.Owner.ID == accountID
+}
+
+// Post stores a chat message in the room history and broadcasts it.
+func (r *Room) Post(msg protocol.ChatMessage) {
+	r.postMutex.Lock()
+	defer r.postMutex.Unlock()
+
+	r.History.Add(msg)
+	r.Broadcast(msg)
+}
+
 func (r *Room) Broadcast(msg protocol.ChatMessage) {
-	pkt := msg.ToPacket()
+	r.BroadcastPacket(msg.ToPacket())
+
+	if !msg.IsServer && !msg.IsCommand {
+		ev := newWebhookEvent(WebhookEventMessage, r.ChatRoom())
+		ev.Message = &msg
+		r.Webhooks.Send(ev)
+	}
+}
+
+// BroadcastPacket queues the packet to every client of the room. It is encoded
+// once, and the same bytes are written to every client.
+func (r *Room) BroadcastPacket(pkt *protocol.Packet) {
+	start := time.Now()
+	data, err := pkt.ToBinary()
+	if err != nil {
+		slog.Error("error encoding broadcast packet", "err", err)
+		return
+	}
+	// writes are only queued, so holding the lock does not wait for slow
+	// clients
+	r.broadcastMutex.Lock()
 	for _, client := range r.Clients.List() {
-		client.Conn.WritePacket(pkt)
+		client.Conn.Write(data)
 	}
+	r.broadcastMutex.Unlock()
+	messagesBroadcast.Inc()
+	broadcastDuration.Observe(time.Since(start).Seconds())
 }
 
 type RoomList struct {
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/jnaraujo/letschat/pkg/protocol"
+)
+
+const DefaultSendQueueSize = 256
+
+var ErrSlowConsumer = errors.New("client is not reading its messages fast enough")
+
+// OverflowPolicy decides what happens when the send queue of a client is full.
+type OverflowPolicy int
+
+const (
+	// DisconnectSlowConsumer closes the connection of the client.
+	DisconnectSlowConsumer OverflowPolicy = iota
+	// DropOldest discards the oldest packet waiting in the queue.
+	DropOldest
+)
+
+// ParseOverflowPolicy parses "disconnect" or "drop-oldest".
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch s {
+	case "disconnect":
+		return DisconnectSlowConsumer, nil
+	case "drop-oldest":
+		return DropOldest, nil
+	default:
+		return 0, fmt.Errorf("invalid overflow policy %q", s)
+	}
+}
+
+// WithSendQueue sets the number of packets waiting to be written each client
+// can have, and what to do when a client has too many.
+func WithSendQueue(size int, policy OverflowPolicy) Option {
+	return func(s *Server) {
+		s.sendQueueSize = max(size, 1)
+		s.overflowPolicy = policy
+	}
+}
+
+// sendQueue is a Connection whose writes are queued and written by its own
+// goroutine, so a slow client does not block whoever is writing to it. Reads go
+// straight to the wrapped connection. The queue stops, and closes the wrapped
+// connection, when it is closed or its context is cancelled.
+type sendQueue struct {
+	Connection
+
+	packets chan []byte
+	policy  OverflowPolicy
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+func newSendQueue(ctx context.Context, conn Connection, size int, policy OverflowPolicy) *sendQueue {
+	q := &sendQueue{
+		Connection: conn,
+		packets:    make(chan []byte, size),
+		policy:     policy,
+	}
+	q.ctx, q.cancel = context.WithCancel(ctx)
+	go q.run()
+	return q
+}
+
+// Write queues the data. The data must not be modified afterwards, so the same
+// slice can be queued for every client of a room.
+func (q *sendQueue) Write(data []byte) error {
+	if q.ctx.Err() != nil {
+		return ErrConnectionClosed
+	}
+
+	for {
+		select {
+		case q.packets <- data:
+			return nil
+		default:
+		}
+
+		switch q.policy {
+		case DropOldest:
+			select {
+			case <-q.packets:
+				droppedPackets.Inc()
+			default:
+			}
+		default:
+			slowConsumers.Inc()
+			q.cancel()
+			q.Connection.Close()
+			return ErrSlowConsumer
+		}
+	}
+}
+
+func (q *sendQueue) WritePacket(pkt *protocol.Packet) error {
+	data, err := pkt.ToBinary()
+	if err != nil {
+		slog.Error("error encoding packet", "type", pkt.Header.PacketType, "err", err)
+		return err
+	}
+	return q.Write(data)
+}
+
+// Close stops accepting writes. The packets already queued are written before
+// the wrapped connection is closed.
+func (q *sendQueue) Close() error {
+	q.cancel()
+	return nil
+}
+
+func (q *sendQueue) run() {
+	defer q.Connection.Close()
+
+	for {
+		select {
+		case data := <-q.packets:
+			if err := q.Connection.Write(data); err != nil {
+				q.cancel()
+				return
+			}
+		case <-q.ctx.Done():
+			q.flush()
+			return
+		}
+	}
+}
+
+func (q *sendQueue) flush() {
+	for {
+		select {
+		case data := <-q.packets:
+			if err := q.Connection.Write(data); err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
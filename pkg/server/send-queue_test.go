@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jnaraujo/letschat/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockedConnection is a connection whose writes wait until it is unblocked.
+type blockedConnection struct {
+	unblock chan struct{}
+	closed  chan struct{}
+
+	mutex   sync.Mutex
+	written [][]byte
+}
+
+func newBlockedConnection() *blockedConnection {
+	return &blockedConnection{
+		unblock: make(chan struct{}),
+		closed:  make(chan struct{}),
+	}
+}
+
+func (bc *blockedConnection) Write(data []byte) error {
+	select {
+	case <-bc.unblock:
+	case <-bc.closed:
+		return ErrConnectionClosed
+	}
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+	bc.written = append(bc.written, data)
+	return nil
+}
+
+func (bc *blockedConnection) Written() [][]byte {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+	return bc.written
+}
+
+func (bc *blockedConnection) Read() ([]byte, error)                  { return nil, ErrConnectionClosed }
+func (bc *blockedConnection) WritePacket(pkt *protocol.Packet) error { return nil }
+func (bc *blockedConnection) ReadPacket() (*protocol.Packet, error)  { return nil, ErrConnectionClosed }
+func (bc *blockedConnection) RemoteAddr() string                     { return "test" }
+func (bc *blockedConnection) Ping() error                            { return nil }
+
+func (bc *blockedConnection) Close() error {
+	select {
+	case <-bc.closed:
+	default:
+		close(bc.closed)
+	}
+	return nil
+}
+
+func TestSendQueueDropOldest(t *testing.T) {
+	conn := newBlockedConnection()
+	q := newSendQueue(context.Background(), conn, 2, DropOldest)
+
+	// the first write is taken by the writer goroutine, which then blocks
+	require.NoError(t, q.Write([]byte("1")))
+	require.Eventually(t, func() bool { return len(q.packets) == 0 }, time.Second, time.Millisecond)
+
+	for _, data := range []string{"2", "3", "4", "5"} {
+		start := time.Now()
+		require.NoError(t, q.Write([]byte(data)))
+		assert.Less(t, time.Since(start), 100*time.Millisecond, "write blocked")
+	}
+
+	close(conn.unblock)
+	q.Close()
+	<-conn.closed
+
+	var written []string
+	for _, data := range conn.Written() {
+		written = append(written, string(data))
+	}
+	assert.Equal(t, []string{"1", "4", "5"}, written)
+}
+
+func TestSendQueueDisconnectSlowConsumer(t *testing.T) {
+	conn := newBlockedConnection()
+	q := newSendQueue(context.Background(), conn, 1, DisconnectSlowConsumer)
+
+	require.NoError(t, q.Write([]byte("1")))
+	require.Eventually(t, func() bool { return len(q.packets) == 0 }, time.Second, time.Millisecond)
+	require.NoError(t, q.Write([]byte("2")))
+
+	assert.ErrorIs(t, q.Write([]byte("3")), ErrSlowConsumer)
+	select {
+	case <-conn.closed:
+	case <-time.After(time.Second):
+		t.Fatal("slow consumer was not disconnected")
+	}
+	assert.ErrorIs(t, q.Write([]byte("4")), ErrConnectionClosed)
+}
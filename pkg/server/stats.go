@@ -0,0 +1,44 @@
+package server
+
+import (
+	"runtime"
+	"time"
+)
+
+// Stats is a snapshot of the state of the server.
+type Stats struct {
+	StartedAt time.Time     `json:"started_at"`
+	Uptime    time.Duration `json:"-"`
+	// UptimeSeconds is Uptime in a unit friendlier to JSON readers.
+	UptimeSeconds float64 `json:"uptime_seconds"`
+	Rooms         int     `json:"rooms"`
+	Clients       int     `json:"clients"`
+	// Connections also counts the clients that did not authenticate yet.
+	Connections int `json:"connections"`
+	Goroutines  int `json:"goroutines"`
+	// HeapAlloc is the number of bytes allocated by live objects.
+	HeapAlloc uint64 `json:"heap_alloc"`
+}
+
+func (s *Server) Stats() Stats {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	rooms := s.rooms.List()
+	clients := 0
+	for _, room := range rooms {
+		clients += len(room.Clients.List())
+	}
+
+	uptime := time.Since(s.startedAt)
+	return Stats{
+		StartedAt:     s.startedAt,
+		Uptime:        uptime,
+		UptimeSeconds: uptime.Seconds(),
+		Rooms:         len(rooms),
+		Clients:       clients,
+		Connections:   int(s.connections.Load()),
+		Goroutines:    runtime.NumGoroutine(),
+		HeapAlloc:     mem.HeapAlloc,
+	}
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// CertCheckInterval is how often a CertReloader checks whether its files
+	// changed.
+	CertCheckInterval = 10 * time.Second
+	// SelfSignedValidity is how long a self-signed certificate is valid for.
+	SelfSignedValidity = 365 * 24 * time.Hour
+)
+
+// WithTLS makes Run serve HTTPS, so clients connect with wss://.
+func WithTLS(config *tls.Config) Option {
+	return func(s *Server) {
+		s.tlsConfig = config
+	}
+}
+
+// NewTLSConfig returns the TLS configuration used by the server with the given
+// certificates.
+func NewTLSConfig(certs ...tls.Certificate) *tls.Config {
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: certs,
+	}
+}
+
+// CertReloader serves a certificate read from files, and reads them again when
+// they change, so a renewed certificate is used without a restart.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+
+	mutex     sync.Mutex
+	cert      *tls.Certificate
+	certMod   time.Time
+	keyMod    time.Time
+	checkedAt time.Time
+}
+
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		interval: CertCheckInterval,
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// TLSConfig returns a TLS configuration serving the certificate of r.
+func (r *CertReloader) TLSConfig() *tls.Config {
+	config := NewTLSConfig()
+	config.GetCertificate = r.GetCertificate
+	return config
+}
+
+// GetCertificate returns the current certificate. It is used as
+// tls.Config.GetCertificate.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if time.Since(r.checkedAt) >= r.interval {
+		r.checkedAt = time.Now()
+		// the files can be in the middle of being replaced, so a certificate
+		// that fails to load is tried again on the next check
+		if err := r.reload(); err != nil {
+			slog.Error("failed to reload the TLS certificate", "err", err)
+		}
+	}
+	return r.cert, nil
+}
+
+// reload reads the files if they changed since they were last read. The mutex
+// must be held, or r not shared yet.
+func (r *CertReloader) reload() error {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return err
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return err
+	}
+	if r.cert != nil && certInfo.ModTime().Equal(r.certMod) && keyInfo.ModTime().Equal(r.keyMod) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	if r.cert != nil {
+		slog.Info("TLS certificate reloaded", "cert", r.certFile)
+	}
+	r.cert = &cert
+	r.certMod = certInfo.ModTime()
+	r.keyMod = keyInfo.ModTime()
+	return nil
+}
+
+// SelfSignedCertificate generates a certificate for the given hosts, names or
+// IP addresses, signed by itself. It is meant for development: clients have to
+// trust it explicitly, for example by pinning its fingerprint.
+func SelfSignedCertificate(hosts ...string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{"LetsChat development"}},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(SelfSignedValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		// a CA, so it can be added to the certificate pool of a client
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+// LoadSelfSignedCertificate reads the certificate and key in the files. When
+// they do not exist, it generates a self-signed certificate for the hosts and
+// writes it to them, so the certificate, and its fingerprint, stays the same
+// across restarts. Remove the files to generate a new one.
+func LoadSelfSignedCertificate(certFile, keyFile string, hosts ...string) (tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err == nil && cert.Leaf == nil {
+		cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	}
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return cert, err
+	}
+
+	cert, err = SelfSignedCertificate(hosts...)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if err := os.MkdirAll(filepath.Dir(certFile), 0o700); err != nil {
+		return tls.Certificate{}, err
+	}
+	if err := os.MkdirAll(filepath.Dir(keyFile), 0o700); err != nil {
+		return tls.Certificate{}, err
+	}
+	// the key is written first, so a certificate is never left without it
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0o600)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o644)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return cert, nil
+}
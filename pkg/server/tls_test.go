@@ -0,0 +1,99 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCertificate writes a new self-signed certificate and its key in PEM.
+func writeCertificate(t *testing.T, certFile, keyFile string, modTime time.Time) tls.Certificate {
+	t.Helper()
+	cert, err := SelfSignedCertificate("localhost")
+	require.NoError(t, err)
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0o600))
+	require.NoError(t, os.Chtimes(certFile, modTime, modTime))
+	require.NoError(t, os.Chtimes(keyFile, modTime, modTime))
+	return cert
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	first := writeCertificate(t, certFile, keyFile, time.Now().Add(-time.Hour))
+
+	r, err := NewCertReloader(certFile, keyFile)
+	require.NoError(t, err)
+	r.interval = 0
+
+	cert, err := r.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, first.Certificate, cert.Certificate)
+
+	second := writeCertificate(t, certFile, keyFile, time.Now())
+	cert, err = r.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, second.Certificate, cert.Certificate)
+
+	// a broken certificate is not served, the last one is kept
+	require.NoError(t, os.WriteFile(certFile, []byte("broken"), 0o600))
+	require.NoError(t, os.Chtimes(certFile, time.Now().Add(time.Hour), time.Now().Add(time.Hour)))
+	cert, err = r.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, second.Certificate, cert.Certificate)
+}
+
+func TestNewCertReloaderMissingFiles(t *testing.T) {
+	_, err := NewCertReloader(filepath.Join(t.TempDir(), "cert.pem"), filepath.Join(t.TempDir(), "key.pem"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestSelfSignedCertificate(t *testing.T) {
+	cert, err := SelfSignedCertificate("localhost", "127.0.0.1")
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert.Leaf)
+	for _, host := range []string{"localhost", "127.0.0.1"} {
+		_, err := cert.Leaf.Verify(x509.VerifyOptions{DNSName: host, Roots: pool})
+		assert.NoError(t, err, host)
+	}
+	_, err = cert.Leaf.Verify(x509.VerifyOptions{DNSName: "example.com", Roots: pool})
+	assert.Error(t, err)
+}
+
+func TestLoadSelfSignedCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls", "cert.pem")
+	keyFile := filepath.Join(dir, "tls", "key.pem")
+
+	first, err := LoadSelfSignedCertificate(certFile, keyFile, "localhost")
+	require.NoError(t, err)
+	assert.FileExists(t, certFile)
+	info, err := os.Stat(keyFile)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	// the same certificate is used after a restart
+	second, err := LoadSelfSignedCertificate(certFile, keyFile, "localhost")
+	require.NoError(t, err)
+	assert.Equal(t, first.Certificate, second.Certificate)
+	assert.Equal(t, first.Leaf.Raw, second.Leaf.Raw)
+
+	// a broken file is reported instead of being replaced
+	require.NoError(t, os.WriteFile(certFile, []byte("broken"), 0o600))
+	_, err = LoadSelfSignedCertificate(certFile, keyFile, "localhost")
+	assert.Error(t, err)
+}
@@ -0,0 +1,391 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jnaraujo/letschat/pkg/account"
+	"github.com/jnaraujo/letschat/pkg/id"
+	"github.com/jnaraujo/letschat/pkg/protocol"
+	"github.com/jnaraujo/letschat/pkg/secure"
+)
+
+const (
+	WebhookEventMessage = "message"
+	WebhookEventJoin    = "join"
+	WebhookEventLeave   = "leave"
+)
+
+const (
+	// WebhookSignatureHeader has the HMAC-SHA256 of the request body, keyed
+	// with the secret of the webhook, as "sha256=<hex>".
+	WebhookSignatureHeader = "X-Letschat-Signature"
+	WebhookEventHeader     = "X-Letschat-Event"
+
+	DefaultWebhookAttempts = 5
+	DefaultWebhookBackoff  = time.Second
+	MaxWebhooksPerRoom     = 5
+	webhookQueueSize       = 256
+	webhookTimeout         = 10 * time.Second
+)
+
+var (
+	ErrInvalidWebhookURL = errors.New("invalid webhook URL, it must be an http or https URL")
+	ErrWebhooksDisabled  = errors.New("webhooks are disabled on this server")
+	ErrWebhookQueueFull  = errors.New("webhook queue is full")
+	ErrWebhookHost       = errors.New("webhooks cannot be sent to this host")
+	ErrWebhookAddress    = errors.New("webhooks cannot be sent to loopback, private or link-local addresses")
+	ErrTooManyWebhooks   = fmt.Errorf("a room can have at most %d webhooks", MaxWebhooksPerRoom)
+)
+
+// sharedAddressSpace is used by carrier-grade NATs, it is not public either.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// WebhookEvent is the JSON body posted to webhooks. Account is set for joins
+// and leaves and Message for messages.
+type WebhookEvent struct {
+	ID        id.ID                 `json:"id"`
+	Type      string                `json:"type"`
+	Room      protocol.ChatRoom     `json:"room"`
+	Account   *account.Account      `json:"account,omitempty"`
+	Message   *protocol.ChatMessage `json:"message,omitempty"`
+	CreatedAt time.Time             `json:"created_at"`
+}
+
+func newWebhookEvent(eventType string, room protocol.ChatRoom) WebhookEvent {
+	return WebhookEvent{
+		ID:        id.NewID(22),
+		Type:      eventType,
+		Room:      room,
+		CreatedAt: time.Now(),
+	}
+}
+
+// WebhookDeadLetter is logged for each event that could not be delivered.
+type WebhookDeadLetter struct {
+	WebhookID id.ID        `json:"webhook_id"`
+	URL       string       `json:"url"`
+	Event     WebhookEvent `json:"event"`
+	Attempts  int          `json:"attempts"`
+	Error     string       `json:"error"`
+	FailedAt  time.Time    `json:"failed_at"`
+}
+
+// SignWebhook returns the signature of a webhook body, as sent in the
+// WebhookSignatureHeader.
+func SignWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookSender delivers the events of every webhook. Failed deliveries are
+// retried with exponential backoff, and events that still fail are written to
+// the dead-letter log as JSON lines.
+//
+// Anyone who owns a room can add webhooks, so they are not sent to loopback,
+// private or link-local addresses, where they could reach services only the
+// server can. The address is checked when connecting, after the name is
+// resolved, so a name cannot resolve to another address later.
+type WebhookSender struct {
+	Client      *http.Client
+	MaxAttempts int
+	Backoff     time.Duration
+	// AllowedHosts, when not empty, are the only hosts webhooks can post to.
+	AllowedHosts []string
+	// AllowPrivateNetworks lets webhooks post to loopback, private and
+	// link-local addresses.
+	AllowPrivateNetworks bool
+
+	deadLetters io.Writer
+	mutex       sync.Mutex
+	// running tracks the goroutines of the webhooks, so the server can wait
+	// for them when it shuts down
+	running sync.WaitGroup
+}
+
+func NewWebhookSender(deadLetters io.Writer) *WebhookSender {
+	ws := &WebhookSender{
+		MaxAttempts: DefaultWebhookAttempts,
+		Backoff:     DefaultWebhookBackoff,
+		deadLetters: deadLetters,
+	}
+	dialer := &net.Dialer{Timeout: webhookTimeout, Control: ws.checkAddress}
+	ws.Client = &http.Client{
+		Timeout: webhookTimeout,
+		// no proxy, the address connected to must be the receiver
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: webhookTimeout,
+		},
+		// redirects could lead to a host that is not allowed
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return ws
+}
+
+// checkAddress is the Control function of the dialer, called with the IP
+// address about to be connected to.
+func (ws *WebhookSender) checkAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if !ws.AllowPrivateNetworks && isPrivateAddr(ip) {
+		return fmt.Errorf("%w: %s", ErrWebhookAddress, ip)
+	}
+	return nil
+}
+
+func isPrivateAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() ||
+		sharedAddressSpace.Contains(ip)
+}
+
+// WithWebhooks lets room moderators add webhooks with the /webhook command,
+// delivering their events with the given sender.
+func WithWebhooks(sender *WebhookSender) Option {
+	return func(s *Server) {
+		s.webhooks = sender
+	}
+}
+
+// Webhook posts the events of a room to an URL. Events are delivered in order
+// by a goroutine of its own, which stops when the webhook is closed or the
+// context it was created with is done.
+type Webhook struct {
+	ID     id.ID
+	URL    string
+	Secret string
+
+	sender *WebhookSender
+	queue  chan WebhookEvent
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewWebhook starts a webhook posting to the URL, with a new random secret,
+// until the context is done.
+func (ws *WebhookSender) NewWebhook(ctx context.Context, rawURL string) (*Webhook, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, ErrInvalidWebhookURL
+	}
+	host := u.Hostname()
+	allowed := slices.ContainsFunc(ws.AllowedHosts, func(allowed string) bool {
+		return strings.EqualFold(allowed, host)
+	})
+	if len(ws.AllowedHosts) > 0 && !allowed {
+		return nil, ErrWebhookHost
+	}
+	// checked again when connecting, this only fails early
+	if ip, err := netip.ParseAddr(host); err == nil && !ws.AllowPrivateNetworks && isPrivateAddr(ip) {
+		return nil, ErrWebhookAddress
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	hook := &Webhook{
+		ID:     id.NewID(8),
+		URL:    u.String(),
+		Secret: secure.GenerateRandomString(32),
+		sender: ws,
+		queue:  make(chan WebhookEvent, webhookQueueSize),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	ws.running.Add(1)
+	go hook.run()
+	return hook, nil
+}
+
+// Send queues an event. If the queue is full the event goes straight to the
+// dead-letter log, so a slow receiver never slows down the room.
+func (w *Webhook) Send(ev WebhookEvent) {
+	select {
+	case w.queue <- ev:
+	default:
+		w.sender.deadLetter(w, ev, 0, ErrWebhookQueueFull)
+	}
+}
+
+// Close stops the delivery of events. Queued events are dropped.
+func (w *Webhook) Close() {
+	w.cancel()
+}
+
+func (w *Webhook) run() {
+	defer w.sender.running.Done()
+
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+		case ev := <-w.queue:
+			w.sender.deliver(w, ev)
+		}
+	}
+}
+
+// wait waits for the webhooks to stop, once their context is done, then closes
+// the connections they left open.
+func (ws *WebhookSender) wait() {
+	ws.running.Wait()
+	ws.Client.CloseIdleConnections()
+}
+
+func (ws *WebhookSender) deliver(w *Webhook, ev WebhookEvent) {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		panic(err)
+	}
+
+	attempt := 0
+	for {
+		attempt++
+		retry, err := ws.post(w, ev.Type, body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt >= ws.MaxAttempts {
+			ws.deadLetter(w, ev, attempt, err)
+			return
+		}
+
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-time.After(ws.Backoff << (attempt - 1)):
+		}
+	}
+}
+
+// post sends the body once, returning whether it is worth retrying on failure.
+func (ws *WebhookSender) post(w *Webhook, eventType string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(w.ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, eventType)
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(w.Secret, body))
+
+	res, err := ws.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(io.Discard, io.LimitReader(res.Body, 4096))
+	res.Body.Close()
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("receiver responded with %s", res.Status)
+	// other client errors will not go away by trying again
+	retry := res.StatusCode >= 500 ||
+		res.StatusCode == http.StatusRequestTimeout ||
+		res.StatusCode == http.StatusTooManyRequests
+	return retry, err
+}
+
+func (ws *WebhookSender) deadLetter(w *Webhook, ev WebhookEvent, attempts int, err error) {
+	slog.Error("failed to deliver webhook event",
+		"webhook", w.ID,
+		"event", ev.ID,
+		"attempts", attempts,
+		"err", err,
+	)
+	if ws.deadLetters == nil {
+		return
+	}
+
+	line, _ := json.Marshal(WebhookDeadLetter{
+		WebhookID: w.ID,
+		URL:       w.URL,
+		Event:     ev,
+		Attempts:  attempts,
+		Error:     err.Error(),
+		FailedAt:  time.Now(),
+	})
+
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+	ws.deadLetters.Write(append(line, '\n'))
+}
+
+// WebhookList keeps the webhooks subscribed to a room.
+type WebhookList struct {
+	hooks []*Webhook
+	mutex sync.RWMutex
+}
+
+func NewWebhookList() *WebhookList {
+	return &WebhookList{}
+}
+
+// Add adds the webhook, unless the room already has MaxWebhooksPerRoom.
+func (wl *WebhookList) Add(hook *Webhook) error {
+	wl.mutex.Lock()
+	defer wl.mutex.Unlock()
+
+	if len(wl.hooks) >= MaxWebhooksPerRoom {
+		return ErrTooManyWebhooks
+	}
+	wl.hooks = append(wl.hooks, hook)
+	return nil
+}
+
+// Remove closes and removes the webhook, returning false if it does not exist.
+func (wl *WebhookList) Remove(id id.ID) bool {
+	wl.mutex.Lock()
+	defer wl.mutex.Unlock()
+
+	i := slices.IndexFunc(wl.hooks, func(hook *Webhook) bool {
+		return hook.ID == id
+	})
+	if i == -1 {
+		return false
+	}
+	wl.hooks[i].Close()
+	wl.hooks = slices.Delete(wl.hooks, i, i+1)
+	return true
+}
+
+func (wl *WebhookList) List() []*Webhook {
+	wl.mutex.RLock()
+	defer wl.mutex.RUnlock()
+
+	return slices.Clone(wl.hooks)
+}
+
+// Send queues the event in every webhook.
+func (wl *WebhookList) Send(ev WebhookEvent) {
+	for _, hook := range wl.List() {
+		hook.Send(ev)
+	}
+}
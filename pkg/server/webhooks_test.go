@@ -0,0 +1,203 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jnaraujo/letschat/pkg/account"
+	"github.com/jnaraujo/letschat/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestWebhookSender(deadLetters io.Writer) *WebhookSender {
+	sender := NewWebhookSender(deadLetters)
+	sender.MaxAttempts = 3
+	sender.Backoff = time.Millisecond
+	// the receivers of the tests listen on loopback
+	sender.AllowPrivateNetworks = true
+	return sender
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	received := make(chan WebhookEvent, 10)
+	var hook *Webhook
+	var attempts atomic.Int32
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, SignWebhook(hook.Secret, body), r.Header.Get(WebhookSignatureHeader))
+
+		// the first attempt fails, so the event must be retried
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		var ev WebhookEvent
+		assert.NoError(t, json.Unmarshal(body, &ev))
+		assert.Equal(t, ev.Type, r.Header.Get(WebhookEventHeader))
+		received <- ev
+	}))
+	defer receiver.Close()
+
+	var err error
+	hook, err = newTestWebhookSender(nil).NewWebhook(context.Background(), receiver.URL)
+	require.NoError(t, err)
+
+	room := NewRoom("test", nil)
+	room.Webhooks.Add(hook)
+	defer room.Webhooks.Remove(hook.ID)
+
+	author := account.NewAccount("alice")
+	room.Post(protocol.NewChatMessage(author, "hello", room.ChatRoom(), time.Now()))
+	// server messages are not sent
+	room.Broadcast(protocol.NewServerChatMessage("welcome", room.ChatRoom(), time.Now()))
+	room.Post(protocol.NewChatMessage(author, "bye", room.ChatRoom(), time.Now()))
+
+	for _, content := range []string{"hello", "bye"} {
+		select {
+		case ev := <-received:
+			assert.Equal(t, WebhookEventMessage, ev.Type)
+			assert.Equal(t, room.ID, ev.Room.ID)
+			require.NotNil(t, ev.Message)
+			assert.Equal(t, content, ev.Message.Content)
+		case <-time.After(2 * time.Second):
+			t.Fatal("webhook event not received")
+		}
+	}
+}
+
+func TestWebhookDeadLetter(t *testing.T) {
+	var attempts atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	deadLetters := &syncBuffer{}
+	hook, err := newTestWebhookSender(deadLetters).NewWebhook(context.Background(), receiver.URL)
+	require.NoError(t, err)
+	defer hook.Close()
+
+	ev := newWebhookEvent(WebhookEventJoin, protocol.ChatRoom{ID: "room"})
+	hook.Send(ev)
+
+	require.Eventually(t, func() bool {
+		return deadLetters.Len() > 0
+	}, 2*time.Second, 5*time.Millisecond)
+
+	var letter WebhookDeadLetter
+	require.NoError(t, json.Unmarshal(deadLetters.Bytes(), &letter))
+	assert.Equal(t, hook.ID, letter.WebhookID)
+	assert.Equal(t, ev.ID, letter.Event.ID)
+	assert.Equal(t, 3, letter.Attempts)
+	assert.EqualValues(t, 3, attempts.Load())
+}
+
+func TestNewWebhookInvalidURL(t *testing.T) {
+	sender := NewWebhookSender(nil)
+	for _, u := range []string{"ftp://example.com", "example.com", "http://"} {
+		_, err := sender.NewWebhook(context.Background(), u)
+		assert.ErrorIs(t, err, ErrInvalidWebhookURL, u)
+	}
+}
+
+func TestWebhookPrivateAddresses(t *testing.T) {
+	sender := NewWebhookSender(nil)
+	for _, u := range []string{
+		"http://127.0.0.1/", "http://10.0.0.1/", "http://192.168.1.1:8080/",
+		"http://169.254.169.254/latest/meta-data", "http://[::1]/", "http://[::ffff:127.0.0.1]/",
+	} {
+		_, err := sender.NewWebhook(context.Background(), u)
+		assert.ErrorIs(t, err, ErrWebhookAddress, u)
+	}
+
+	// names are checked when connecting, so they cannot resolve to a private
+	// address either
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("webhook posted to a loopback address")
+	}))
+	defer receiver.Close()
+	u, err := url.Parse(receiver.URL)
+	require.NoError(t, err)
+
+	deadLetters := &syncBuffer{}
+	sender = NewWebhookSender(deadLetters)
+	sender.MaxAttempts = 1
+	hook, err := sender.NewWebhook(context.Background(), "http://localhost:"+u.Port())
+	require.NoError(t, err)
+	defer hook.Close()
+	hook.Send(newWebhookEvent(WebhookEventJoin, protocol.ChatRoom{ID: "room"}))
+
+	require.Eventually(t, func() bool {
+		return deadLetters.Len() > 0
+	}, 2*time.Second, 5*time.Millisecond)
+	var letter WebhookDeadLetter
+	require.NoError(t, json.Unmarshal(deadLetters.Bytes(), &letter))
+	assert.Contains(t, letter.Error, ErrWebhookAddress.Error())
+}
+
+func TestWebhookAllowedHosts(t *testing.T) {
+	sender := NewWebhookSender(nil)
+	sender.AllowedHosts = []string{"hooks.example.com"}
+
+	_, err := sender.NewWebhook(context.Background(), "https://evil.example.com/")
+	assert.ErrorIs(t, err, ErrWebhookHost)
+
+	hook, err := sender.NewWebhook(context.Background(), "https://HOOKS.example.com/letschat")
+	require.NoError(t, err)
+	hook.Close()
+}
+
+func TestWebhookLimit(t *testing.T) {
+	h := newTestHarness(t, WithWebhooks(NewWebhookSender(nil)))
+	alice := h.Connect("alice")
+	room := newTestRoom(t, h, alice, "games")
+	alice.SendCommand("join " + string(room.ID))
+	alice.ExpectServerMessage()
+
+	for range MaxWebhooksPerRoom {
+		alice.SendCommand("webhook add https://hooks.example.com/")
+		assert.Contains(t, alice.ExpectCommandResponse().Content, "added")
+	}
+	alice.SendCommand("webhook add https://hooks.example.com/")
+	assert.Contains(t, alice.ExpectCommandResponse().Content, ErrTooManyWebhooks.Error())
+	assert.Len(t, room.Webhooks.List(), MaxWebhooksPerRoom)
+	for _, hook := range room.Webhooks.List() {
+		room.Webhooks.Remove(hook.ID)
+	}
+}
+
+type syncBuffer struct {
+	buf   bytes.Buffer
+	mutex sync.Mutex
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Len() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Len()
+}
+
+func (b *syncBuffer) Bytes() []byte {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return bytes.Clone(b.buf.Bytes())
+}
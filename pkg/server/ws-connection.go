@@ -2,6 +2,7 @@ package server
 
 import (
 	"errors"
+	"log/slog"
 	"sync"
 	"time"
 
@@ -22,8 +23,11 @@ func (wsc *WSConnection) Write(data []byte) error {
 	wsc.wMutex.Lock()
 	defer wsc.wMutex.Unlock()
 
+	packetSize.WithLabelValues("out").Observe(float64(len(data)))
+	wsc.Conn.SetWriteDeadline(time.Now().Add(WriteTimeout))
 	err := wsc.Conn.WriteMessage(websocket.BinaryMessage, data)
 	if err != nil {
+		writeErrors.Inc()
 		if websocket.IsUnexpectedCloseError(err,
 			websocket.CloseGoingAway, websocket.CloseAbnormalClosure, websocket.CloseNoStatusReceived) {
 			return err
@@ -48,12 +52,14 @@ func (wsc *WSConnection) Read() ([]byte, error) {
 	if messageType != websocket.BinaryMessage {
 		return nil, errors.New("message type should be binary")
 	}
+	packetSize.WithLabelValues("in").Observe(float64(len(data)))
 	return data, nil
 }
 
 func (wsc *WSConnection) WritePacket(pkt *protocol.Packet) error {
 	data, err := pkt.ToBinary()
 	if err != nil {
+		slog.Error("error encoding packet", "type", pkt.Header.PacketType, "err", err)
 		return err
 	}
 	return wsc.Write(data)
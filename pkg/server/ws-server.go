@@ -1,45 +1,185 @@
 package server
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/jnaraujo/letschat/pkg/account"
+	"github.com/jnaraujo/letschat/pkg/audit"
 	"github.com/jnaraujo/letschat/pkg/id"
 	"github.com/jnaraujo/letschat/pkg/protocol"
+	"github.com/jnaraujo/letschat/pkg/sanitize"
 )
 
 const (
-	defaultRoomID id.ID = "ALL"
-	MaxKeepAlive        = 60 * time.Second
-	MaxPing             = MaxKeepAlive / 2
+	defaultRoomID   id.ID = "ALL"
+	MaxKeepAlive          = 60 * time.Second
+	MaxPing               = MaxKeepAlive / 2
+	LatencyInterval       = 10 * time.Second
+	// WriteTimeout is how long writing a packet to a client can take before
+	// its connection is considered broken.
+	WriteTimeout = 10 * time.Second
+	// DefaultMaxMessageLength is the default limit, in characters, of the
+	// content of a message. MaxMessageLength is the highest limit that can be
+	// configured, so every message still fits in a packet.
+	DefaultMaxMessageLength = 2000
+	MaxMessageLength        = 8000
 )
 
+var ErrEmptyMessage = errors.New("message is empty")
+
 type Server struct {
-	rooms *RoomList
+	rooms       *RoomList
+	readMarkers *ReadMarkerList
+	mentions    *MentionList
+	files       *FileStore
+	mux         *http.ServeMux
+
+	maxMessageLength int
+	namePolicy       NamePolicy
+	filters          []MessageFilter
+	wordFilter       *WordFilter
+	plugins          []Plugin
+	webhooks         *WebhookSender
+	startedAt        time.Time
+	readinessChecks  []readinessCheck
+	adminToken       string
+	drainDelay       time.Duration
+	auditLog         *audit.Logger
+	sendQueueSize    int
+	overflowPolicy   OverflowPolicy
+	tlsConfig        *tls.Config
+	// roomMetrics is how many rooms the room_clients metric reports.
+	roomMetrics int
+	// logMessageContent logs the content of the messages received.
+	logMessageContent bool
+	draining          atomic.Bool
+	// connections counts the open WebSocket connections, authenticated or not.
+	connections atomic.Int64
+
+	httpServer      *http.Server
+	httpServerMutex sync.Mutex
+	// ctx is the parent of the context of every connection. It is cancelled
+	// by Shutdown.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// handlers waits for the WebSocket connections to be handled.
+	handlers sync.WaitGroup
+	// bots maps the API tokens to the account of their bot.
+	bots     map[string]*account.Account
+	commands map[string]CommandHandler
+	// namesMutex makes checking and taking a username atomic.
+	namesMutex sync.Mutex
 }
 
-func NewServer() *Server {
-	server := &Server{
-		rooms: NewRoomList(),
+type Option func(s *Server)
+
+// WithMaxMessageLength sets the maximum length of the content of a message, in
+// characters. It is capped at MaxMessageLength.
+func WithMaxMessageLength(n int) Option {
+	return func(s *Server) {
+		s.maxMessageLength = min(n, MaxMessageLength)
 	}
+}
 
+// WithFileStore enables file transfer, keeping the files in the given store.
+// The server is not ready while files cannot be written to the store, and
+// removes the expired files until it shuts down.
+func WithFileStore(files *FileStore) Option {
+	return func(s *Server) {
+		s.files = files
+		s.readinessChecks = append(s.readinessChecks, readinessCheck{name: "files", check: files.Check})
+	}
+}
+
+func NewServer(opts ...Option) *Server {
+	server := &Server{
+		rooms:       NewRoomList(),
+		readMarkers: NewReadMarkerList(),
+		mentions:    NewMentionList(),
+		mux:         http.NewServeMux(),
+		commands:    maps.Clone(commands),
+		startedAt:   time.Now(),
+		drainDelay:  DefaultDrainDelay,
+
+		sendQueueSize:  DefaultSendQueueSize,
+		overflowPolicy: DisconnectSlowConsumer,
+
+		maxMessageLength: DefaultMaxMessageLength,
+	}
+	server.ctx, server.cancel = context.WithCancel(context.Background())
+	for _, opt := range opts {
+		opt(server)
+	}
 	defaultRoom := NewRoom("ALL", nil)
 	defaultRoom.ID = defaultRoomID
 	server.rooms.Add(defaultRoom)
 
-	http.HandleFunc("/lc", server.handleNewConnection)
+	server.mux.HandleFunc("/lc", server.handleNewConnection)
+	server.mux.HandleFunc("PUT /files/{id}", server.handleFileUpload)
+	server.mux.HandleFunc("GET /files/{id}", server.handleFileDownload)
+	server.mux.Handle("GET /metrics", server.metricsHandler())
+	server.mux.HandleFunc("GET /healthz", server.handleHealthz)
+	server.mux.HandleFunc("GET /readyz", server.handleReadyz)
+	server.mux.HandleFunc("GET /debug/stats", server.handleDebugStats)
+	server.mux.HandleFunc("GET /api/rooms", server.withBot(server.handleAPIListRooms))
+	server.mux.HandleFunc("POST /api/rooms", server.withBot(server.handleAPICreateRoom))
+	server.mux.HandleFunc("GET /api/rooms/{id}/clients", server.withBot(server.handleAPIListClients))
+	server.mux.HandleFunc("POST /api/rooms/{id}/messages", server.withBot(server.handleAPIPostMessage))
+
+	if server.files != nil {
+		server.handlers.Add(1)
+		go func() {
+			defer server.handlers.Done()
+			server.files.Run(server.ctx)
+		}()
+	}
+
+	// plugins see the server fully set up
+	for _, plugin := range server.plugins {
+		plugin.Init(server)
+	}
 	return server
 }
 
+// Run serves the server on the address until Shutdown is called. It serves
+// HTTPS when the server was created WithTLS.
 func (s *Server) Run(addr string) error {
-	return http.ListenAndServe(addr, nil)
+	httpServer := &http.Server{
+		Addr:      addr,
+		Handler:   s.mux,
+		TLSConfig: s.tlsConfig,
+	}
+	s.httpServerMutex.Lock()
+	s.httpServer = httpServer
+	s.httpServerMutex.Unlock()
+
+	var err error
+	if s.tlsConfig != nil {
+		// the certificates come from the TLS configuration
+		err = httpServer.ListenAndServeTLS("", "")
+	} else {
+		err = httpServer.ListenAndServe()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Handler returns the HTTP handler serving every endpoint of the server.
+func (s *Server) Handler() http.Handler {
+	return s.mux
 }
 
 var upgrader = websocket.Upgrader{
@@ -50,63 +190,123 @@ var upgrader = websocket.Upgrader{
 }
 
 func (s *Server) handleNewConnection(w http.ResponseWriter, r *http.Request) {
+	err := runHooks(s, func(hook ConnectHook) error {
+		return hook.OnConnect(&ConnectEvent{Addr: getRealIP(r), Request: r})
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		slog.Error("error upgrading connection", "err", err)
 		return
 	}
-	defer conn.Close()
 
-	userIP := getRealIP(r)
+	wsConn := &WSConnection{
+		Conn:   conn,
+		IPAddr: getRealIP(r),
+	}
+	conn.SetReadLimit(protocol.MaxPacketSize)
+	conn.SetReadDeadline(time.Now().Add(MaxKeepAlive))
+	conn.SetPingHandler(func(appData string) error {
+		return wsConn.Ping()
+	})
+
+	s.ServeConn(wsConn)
+}
+
+// ServeConn serves a client on the connection until it disconnects. The
+// client must authenticate first, like it does over WebSocket.
+func (s *Server) ServeConn(conn Connection) {
+	connectedClients.Inc()
+	defer connectedClients.Dec()
+	s.connections.Add(1)
+	defer s.connections.Add(-1)
+	s.handlers.Add(1)
+	defer s.handlers.Done()
+
+	// cancelled when the client disconnects or the server shuts down, which
+	// stops every goroutine serving the client and closes its connection
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
 
 	// unauthenticated user
 	client := NewClient(
+		ctx,
 		account.NewAccount("Anonymous"),
-		&WSConnection{
-			Conn:   conn,
-			IPAddr: userIP,
-		},
+		newSendQueue(ctx, conn, s.sendQueueSize, s.overflowPolicy),
 	)
+	// the packets still queued are written before the connection is closed
+	defer client.Disconnect()
 
-	conn.SetReadDeadline(time.Now().Add(MaxKeepAlive))
-	conn.SetPingHandler(func(appData string) error {
-		return client.Conn.Ping()
-	})
-
-	err = s.handleAuth(client)
+	err := s.handleAuth(client)
 	if err != nil {
 		if errors.Is(err, ErrConnectionClosed) {
 			return
 		}
+		authFailures.WithLabelValues(authFailureReason(err)).Inc()
+		content := "failed to auth"
+		// clients of another version are told why, so they know to update
+		if isUsernameError(err) || isVetoError(err) || errors.Is(err, protocol.ErrProtocolVersionMismatch) {
+			content = err.Error()
+		}
 		client.Conn.WritePacket(
 			protocol.ServerAuthMessage{
 				Status:  "auth_error",
-				Content: "failed to auth",
+				Content: content,
 			}.ToPacket(),
 		)
 		slog.Error("failed to initialize connection", "err", err)
 		return
 	}
 
-	slog.Info("client authenticated", "addr", client.Conn.RemoteAddr(), "username", client.Account.Username, "id", client.Account.ID)
+	slog.Info("client authenticated", "addr", client.Conn.RemoteAddr(), "username", client.Account().Username, "id", client.Account().ID)
 
-	clientRoom := s.rooms.Find(client.RoomID)
+	clientRoom := s.rooms.Find(client.RoomID())
 	if clientRoom == nil {
 		fmt.Println("client room does not exists")
 		return
 	}
+	s.audit(audit.AuthSuccess, client, nil, "", "")
+	s.audit(audit.RoomJoin, client, clientRoom, "", "")
 	defer func() {
-		room := s.rooms.Find(client.RoomID)
+		room := s.rooms.Find(client.RoomID())
 		if room != nil {
-			room.RemoveClient(client.Account.ID)
+			s.leaveRoom(client, room)
 		}
+		notifyHooks(s, func(hook DisconnectHook) {
+			hook.OnDisconnect(&DisconnectEvent{Client: client})
+		})
+		// accounts only live as long as their connection
+		s.readMarkers.Remove(client.Account().ID)
+		s.mentions.Remove(client.Account().ID)
 	}()
 
+	go s.pingClient(client)
+
 	s.handleIncomingMessages(client)
 }
 
-func (s *Server) handleAuth(client *Client) error {
+func (s *Server) handleAuth(client *Client) (err error) {
+	// the username the client tried to use, for the audit log
+	var attempted string
+	defer func() {
+		if err != nil && !errors.Is(err, ErrConnectionClosed) {
+			// the client has no account yet
+			ev := audit.NewEvent(audit.AuthFailure, nil)
+			ev.IP = client.Conn.RemoteAddr()
+			ev.Target = attempted
+			ev.Detail = err.Error()
+			s.auditLog.Log(ev)
+		}
+	}()
+
 	pkt, err := client.Conn.ReadPacket()
+	if errors.Is(err, protocol.ErrProtocolVersionMismatch) {
+		return fmt.Errorf("%w, the server uses version %d", err, protocol.ProtocolVersion)
+	}
 	if err != nil {
 		return err
 	}
@@ -120,18 +320,24 @@ func (s *Server) handleAuth(client *Client) error {
 		return err
 	}
 
-	if len(authMsg.Username) <= 3 {
-		return errors.New("username is too short")
-	}
-	if len(authMsg.Username) >= 16 {
-		return errors.New("username is too long")
+	attempted = authMsg.Username
+
+	ev := &AuthEvent{Client: client, Username: authMsg.Username, RoomID: authMsg.RoomID}
+	err = runHooks(s, func(hook AuthHook) error {
+		return hook.OnAuth(ev)
+	})
+	if err != nil {
+		return err
 	}
 
-	client.Account.Username = authMsg.Username
+	username, err := validateUsername(ev.Username)
+	if err != nil {
+		return err
+	}
 
 	var room *Room
-	if authMsg.RoomID != "" && s.rooms.Has(authMsg.RoomID) {
-		room = s.rooms.Find(authMsg.RoomID)
+	if ev.RoomID != "" && s.rooms.Has(ev.RoomID) {
+		room = s.rooms.Find(ev.RoomID)
 	} else {
 		room = s.rooms.Find(defaultRoomID)
 		if room == nil {
@@ -139,12 +345,28 @@ func (s *Server) handleAuth(client *Client) error {
 		}
 	}
 
+	err = runHooks(s, func(hook JoinHook) error {
+		return hook.OnJoin(&JoinEvent{Client: client, Room: room})
+	})
+	if err != nil {
+		return err
+	}
+
+	s.namesMutex.Lock()
+	defer s.namesMutex.Unlock()
+
+	err = s.checkUsernameAvailable(username, room.ID, client.Account().ID)
+	if err != nil {
+		return err
+	}
+	client.SetUsername(username)
+
 	err = client.Conn.WritePacket(
 		protocol.ServerAuthMessage{
 			Status:  "ok",
 			Content: "account authenticated",
-			RoomID:  client.RoomID, // TODO: should check whether the room exists
-			Account: client.Account,
+			RoomID:  room.ID,
+			Account: client.Account(),
 		}.ToPacket(),
 	)
 	if err != nil {
@@ -171,19 +393,45 @@ func (s *Server) handleIncomingMessages(client *Client) {
 			break
 		}
 
-		if pkt.Header.PacketType == protocol.PacketTypePing {
-			client.Conn.Ping()
+		switch pkt.Header.PacketType {
+		case protocol.PacketTypePing:
+			s.handlePing(client, pkt)
+			continue
+		case protocol.PacketTypePong:
+			s.handlePong(client, pkt)
+			continue
+		case protocol.PacketTypeMessageEdit:
+			s.handleMessageEdit(client, pkt)
+			continue
+		case protocol.PacketTypeMessageDelete:
+			s.handleMessageDelete(client, pkt)
+			continue
+		case protocol.PacketTypeReaction:
+			s.handleReaction(client, pkt)
+			continue
+		case protocol.PacketTypeTyping:
+			s.handleTyping(client, pkt)
+			continue
+		case protocol.PacketTypeReadMarker:
+			s.handleReadMarker(client, pkt)
+			continue
+		case protocol.PacketTypeFileOffer:
+			s.handleFileOffer(client, pkt)
 			continue
 		}
 
-		var msg protocol.ChatMessage
-		err = json.Unmarshal(pkt.Payload, &msg)
+		msg, err := protocol.ChatMessageFromPacket(pkt)
 		if err != nil {
 			slog.Error("error reading message", "err", err)
 			continue
 		}
 
-		if len(msg.Content) == 0 || len(msg.Content) > 100 {
+		msg.Content, err = s.checkContent(msg.Content)
+		if errors.Is(err, ErrEmptyMessage) {
+			continue
+		}
+		if err != nil {
+			sendCommandError(client, "Failed to send message", err)
 			continue
 		}
 
@@ -192,26 +440,133 @@ func (s *Server) handleIncomingMessages(client *Client) {
 			continue
 		}
 
-		room := s.rooms.Find(client.RoomID)
+		room := s.rooms.Find(client.RoomID())
 		if room == nil {
 			continue
 		}
 
+		chatMsg, err := s.postMessage(client, client.Account(), room, msg.Content, msg.ReplyTo)
+		if err != nil {
+			sendCommandError(client, "Failed to send message", err)
+			continue
+		}
+
+		client.Presence.Touch()
+		// everything sent before the client's own message was seen
+		s.readMarkers.Set(client.Account().ID, room.ID, ReadMarker{
+			MessageID: chatMsg.ID,
+			CreatedAt: chatMsg.CreatedAt,
+		})
+	}
+}
+
+func (s *Server) errMessageTooLong() error {
+	return fmt.Errorf("message is too long, the limit is %d characters", s.maxMessageLength)
+}
+
+// checkContent sanitizes the content of a message and checks its length.
+func (s *Server) checkContent(content string) (string, error) {
+	content, err := sanitize.Text(content)
+	if err != nil {
+		return "", err
+	}
+	if len(content) == 0 {
+		return "", ErrEmptyMessage
+	}
+	if sanitize.Length(content) > s.maxMessageLength {
+		return "", s.errMessageTooLong()
+	}
+	return content, nil
+}
+
+// postMessage posts a message in the room, after it passes the message filters
+// and hooks. The content must already be checked with checkContent. Client is
+// nil for messages posted by bots.
+func (s *Server) postMessage(client *Client, author *account.Account, room *Room,
+	content string, replyTo id.ID) (protocol.ChatMessage, error) {
+	chatMsg := protocol.NewChatMessage(author, content, room.ChatRoom(), time.Now())
+	if replyTo != "" {
+		parent, err := findReplyParent(room, replyTo)
+		if err != nil {
+			return protocol.ChatMessage{}, err
+		}
+		chatMsg.ReplyTo = parent.ID
+		chatMsg.Quote = protocol.NewQuotedMessage(parent)
+	}
+
+	if err := s.screenMessage(client, room, &chatMsg, false); err != nil {
+		return protocol.ChatMessage{}, err
+	}
+	resolveMentions(room, &chatMsg)
+
+	if s.logMessageContent {
 		slog.Info("message received",
-			"from-addr", client.Conn.RemoteAddr(),
-			"from", client.Account.Username,
+			"from", author.Username,
+			"from-id", author.ID,
 			"room", room.Name,
-			"content", msg.Content,
+			"content", chatMsg.Content,
 		)
+	}
+	messagesReceived.Inc()
+	room.Post(chatMsg)
+	s.notifyMentions(room, chatMsg)
+	return chatMsg, nil
+}
 
-		room.Broadcast(
-			protocol.NewChatMessage(
-				client.Account, msg.Content, protocol.ChatRoom{
-					ID:   room.ID,
-					Name: room.Name,
-				}, time.Now(),
-			),
-		)
+// screenMessage runs a message through the message filters and hooks, which
+// can change it or reject it. Every message posted or edited in a room goes
+// through it. Client is nil for messages posted by bots.
+func (s *Server) screenMessage(client *Client, room *Room, msg *protocol.ChatMessage, edit bool) error {
+	content, err := s.filterMessage(room, msg.Content)
+	if err != nil {
+		return err
+	}
+	msg.Content = content
+
+	return runHooks(s, func(hook MessageHook) error {
+		return hook.OnMessage(&MessageEvent{Client: client, Room: room, Message: msg, Edit: edit})
+	})
+}
+
+func (s *Server) handlePing(client *Client, pkt *protocol.Packet) {
+	client.Conn.Ping()
+
+	ping, err := protocol.PingMessageFromPacket(pkt)
+	if err != nil {
+		slog.Error("error reading ping", "err", err)
+		return
+	}
+	client.Conn.WritePacket(ping.Pong().ToPacket())
+}
+
+func (s *Server) handlePong(client *Client, pkt *protocol.Packet) {
+	client.Conn.Ping()
+
+	pong, err := protocol.PongMessageFromPacket(pkt)
+	if err != nil {
+		slog.Error("error reading pong", "err", err)
+		return
+	}
+	client.Latency.HandlePong(pong)
+}
+
+// pingClient periodically sends pings to the client so its latency can be
+// measured from the server side, until the client disconnects.
+func (s *Server) pingClient(client *Client) {
+	ticker := time.NewTicker(LatencyInterval)
+	defer ticker.Stop()
+
+	for {
+		ping, _ := client.Latency.NewPing()
+		if err := client.Conn.WritePacket(ping.ToPacket()); err != nil {
+			return
+		}
+
+		select {
+		case <-client.Context().Done():
+			return
+		case <-ticker.C:
+		}
 	}
 }
 
@@ -222,36 +577,61 @@ func (s *Server) handleCommand(client *Client, msg *protocol.ChatMessage) {
 		Server:        s,
 	}
 
-	// TODO: fix this
-	if strings.HasPrefix(msg.Content, "ls") {
-		lsCommand(cmdProps)
-		return
-	}
-	if strings.HasPrefix(msg.Content, "ping") {
-		pingCommand(cmdProps)
+	name, args, _ := strings.Cut(msg.Content, " ")
+	command, ok := s.commands[name]
+	if !ok {
+		commandsTotal.WithLabelValues("unknown").Inc()
+		client.Conn.WritePacket(
+			protocol.NewCommandChatMessage(
+				"command not found", time.Now(),
+			).ToPacket(),
+		)
 		return
 	}
-	if strings.HasPrefix(msg.Content, "join") {
-		joinRoomCommand(cmdProps)
+
+	err := runHooks(s, func(hook CommandHook) error {
+		return hook.OnCommand(&CommandEvent{Client: client, Name: name, Args: args})
+	})
+	if err != nil {
+		sendCommandError(client, "Command refused", err)
 		return
 	}
-	if strings.HasPrefix(msg.Content, "new") {
-		createRoomCommand(cmdProps)
-		return
+	commandsTotal.WithLabelValues(name).Inc()
+	command(cmdProps)
+}
+
+func (s *Server) addClientToRoom(client *Client, roomID id.ID) error {
+	room := s.rooms.Find(roomID)
+	if room == nil {
+		return nil
 	}
 
-	client.Conn.WritePacket(
-		protocol.NewCommandChatMessage(
-			"command not found", time.Now(),
-		).ToPacket(),
-	)
-}
+	err := runHooks(s, func(hook JoinHook) error {
+		return hook.OnJoin(&JoinEvent{Client: client, Room: room})
+	})
+	if err != nil {
+		return err
+	}
 
-func (s *Server) addClientToRoom(client *Client, roomID id.ID) {
-	if s.rooms.Has(client.RoomID) {
-		s.rooms.Find(client.RoomID).RemoveClient(client.Account.ID)
+	s.namesMutex.Lock()
+	err = s.checkUsernameAvailable(client.Account().Username, roomID, client.Account().ID)
+	if err != nil {
+		s.namesMutex.Unlock()
+		return err
 	}
-	if s.rooms.Has(roomID) {
-		s.rooms.Find(roomID).AddClient(client)
+	oldRoom := s.rooms.Find(client.RoomID())
+	if oldRoom != nil {
+		oldRoom.RemoveClient(client.Account().ID)
 	}
+	room.AddClient(client)
+	s.namesMutex.Unlock()
+
+	if oldRoom != nil {
+		s.audit(audit.RoomLeave, client, oldRoom, "", "")
+		notifyHooks(s, func(hook LeaveHook) {
+			hook.OnLeave(&LeaveEvent{Client: client, Room: oldRoom})
+		})
+	}
+	s.audit(audit.RoomJoin, client, room, "", "")
+	return nil
 }
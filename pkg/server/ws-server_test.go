@@ -0,0 +1,134 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jnaraujo/letschat/pkg/id"
+	"github.com/jnaraujo/letschat/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuth(t *testing.T) {
+	h := newTestHarness(t)
+	h.Connect("alice")
+
+	tests := []struct {
+		name     string
+		username string
+		err      error
+	}{
+		{"too short", "abc", ErrUsernameTooShort},
+		{"too long", strings.Repeat("a", MaxUsernameLength+1), ErrUsernameTooLong},
+		{"invalid characters", "bad name!", ErrInvalidUsername},
+		{"taken", "alice", ErrUsernameTaken},
+		{"looks like one taken", "ALICE", ErrUsernameTaken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := h.Dial()
+			res := tc.Auth(tt.username, "")
+			assert.Equal(t, "auth_error", res.Status)
+			assert.Contains(t, res.Content, tt.err.Error())
+			tc.ExpectClosed()
+		})
+	}
+
+	t.Run("length in characters", func(t *testing.T) {
+		// 15 characters, but more bytes
+		res := h.Dial().Auth(strings.Repeat("é", MaxUsernameLength), "")
+		assert.Equal(t, "ok", res.Status, res.Content)
+	})
+}
+
+func TestAuthFirstPacket(t *testing.T) {
+	h := newTestHarness(t)
+
+	tc := h.Dial()
+	tc.SendMessage("hello")
+	res, err := protocol.ServerAuthMessageFromPacket(tc.Expect(protocol.PacketTypeAuth))
+	require.NoError(t, err)
+	assert.Equal(t, "auth_error", res.Status)
+	tc.ExpectClosed()
+}
+
+func TestAuthVersionMismatch(t *testing.T) {
+	h := newTestHarness(t)
+
+	tc := h.Dial()
+	pkt := protocol.ClientAuthMessage{Username: "alice"}.ToPacket()
+	pkt.Header.Version = protocol.ProtocolVersion - 1
+	tc.Send(pkt)
+	res, err := protocol.ServerAuthMessageFromPacket(tc.Expect(protocol.PacketTypeAuth))
+	require.NoError(t, err)
+	assert.Equal(t, "auth_error", res.Status)
+	assert.Contains(t, res.Content, protocol.ErrProtocolVersionMismatch.Error())
+	assert.Contains(t, res.Content, fmt.Sprint(protocol.ProtocolVersion))
+	tc.ExpectClosed()
+}
+
+func TestAuthUnknownRoom(t *testing.T) {
+	h := newTestHarness(t)
+
+	res := h.Dial().Auth("alice", "nope")
+	require.Equal(t, "ok", res.Status)
+	assert.Equal(t, defaultRoomID, res.RoomID)
+}
+
+func TestBroadcast(t *testing.T) {
+	h := newTestHarness(t)
+	clients := h.ConnectAll("alice", "bobby", "carol")
+
+	clients[0].SendMessage("hello")
+	for _, client := range clients {
+		msg := client.ExpectChatMessage()
+		assert.Equal(t, "hello", msg.Content)
+		assert.Equal(t, clients[0].Account.ID, msg.Author.ID)
+		assert.Equal(t, defaultRoomID, msg.Room.ID)
+	}
+
+	clients[1].Close()
+	for _, client := range []*testClient{clients[0], clients[2]} {
+		assert.Contains(t, client.ExpectServerMessage().Content, "bobby ("+string(clients[1].Account.ID)+") left")
+	}
+}
+
+func TestBroadcastOrdering(t *testing.T) {
+	const messages = 50
+
+	h := newTestHarness(t)
+	clients := h.ConnectAll("alice", "bobby", "carol")
+
+	var wg sync.WaitGroup
+	for _, client := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range messages {
+				client.SendMessage(fmt.Sprint(i))
+			}
+		}()
+	}
+	wg.Wait()
+
+	// every client sees the messages of each sender in the order they were
+	// sent, and the same overall order as every other client
+	var orders [][]id.ID
+	for _, client := range clients {
+		next := make(map[id.ID]int)
+		var order []id.ID
+		for range messages * len(clients) {
+			msg := client.ExpectChatMessage()
+			assert.Equal(t, fmt.Sprint(next[msg.Author.ID]), msg.Content)
+			next[msg.Author.ID]++
+			order = append(order, msg.ID)
+		}
+		orders = append(orders, order)
+	}
+	for _, order := range orders[1:] {
+		assert.Equal(t, orders[0], order)
+	}
+}
@@ -1,8 +1,23 @@
 package utils
 
+import "fmt"
+
 func Plural(n int) string {
 	if n == 1 {
 		return ""
 	}
 	return "s"
 }
+
+func FormatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
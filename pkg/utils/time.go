@@ -17,3 +17,15 @@ func FormatDuration(d time.Duration) string {
 		return fmt.Sprintf("%d second%s ago", seconds, Plural(seconds))
 	}
 }
+
+// FormatLatency formats a round-trip time in milliseconds. A zero duration means
+// it was not measured yet.
+func FormatLatency(d time.Duration) string {
+	if d == 0 {
+		return "n/a"
+	}
+	if d < time.Millisecond {
+		return "<1 ms"
+	}
+	return fmt.Sprintf("%d ms", d.Milliseconds())
+}